@@ -0,0 +1,31 @@
+package kv
+
+import (
+	"iter"
+)
+
+// The methods shared by every cache in this module, so implementations can be swapped behind one type.
+type Cache[K comparable, V any] interface {
+	// Gets the value of key, the zero value if it's missing.
+	Get(key K) V
+	// Gets the value of key and if it exists.
+	GetHas(key K) (V, bool)
+	// Sets key to val, overwriting it if it exists.
+	Set(key K, val V)
+	// Sets key to val, errors if key already exists.
+	Add(key K, val V) error
+	// Sets key to val, errors if key doesn't exist.
+	Update(key K, val V) error
+	// Deletes key, returns false if it didn't exist.
+	Delete(key K) bool
+	// Gets the number of items in the cache.
+	Count() int
+	// Deletes every item without calling any callbacks.
+	Clear()
+	// Calls f for every item until it returns false. f must not write to the cache.
+	Range(f func(key K, val V) bool)
+	// Iterators over the items, keys and values for use with range, working like Range.
+	All() iter.Seq2[K, V]
+	Keys() iter.Seq[K]
+	Values() iter.Seq[V]
+}
@@ -2,17 +2,23 @@ package ccmap
 
 import (
 	"fmt"
+	"iter"
 	"sync"
 	"encoding/json"
+
+	"github.com/saintwish/kv"
 )
 
 type Cache[K comparable, V any] struct {
 	Map map[K]V //cached items
 	OnEvicted func(K, V) //function that's called when cached item is deleted automatically
+	OnEvictedReason func(K, V, kv.Reason) //same as OnEvicted but also told why, called for replaced items too
 
 	sync.RWMutex //mutex
 }
 
+var _ kv.Cache[string, any] = (*Cache[string, any])(nil)
+
 func New[K comparable, V any]() *Cache[K, V] {
 	return &Cache[K, V] {
 		Map: make(map[K]V, 0),
@@ -23,6 +29,21 @@ func (c *Cache[K, V]) SetOnEvicted(f func(K, V)) {
 	c.OnEvicted = f
 }
 
+func (c *Cache[K, V]) SetOnEvictedReason(f func(K, V, kv.Reason)) {
+	c.OnEvictedReason = f
+}
+
+// Calls the eviction callbacks, OnEvicted keeps its old behaviour of not being called for replaced items.
+func (c *Cache[K, V]) evicted(key K, val V, reason kv.Reason) {
+	if c.OnEvictedReason != nil {
+		c.OnEvictedReason(key, val, reason)
+	}
+
+	if c.OnEvicted != nil && reason != kv.Replaced {
+		c.OnEvicted(key, val)
+	}
+}
+
 func (c *Cache[K, V]) Get(key K) (data V) {
 	c.RLock()
 
@@ -53,42 +74,133 @@ func (c *Cache[K, V]) Has(key K) (ok bool) {
 func (c *Cache[K, V]) Set(key K, val V) {
 	c.Lock()
 
+	old, ok := c.Map[key]
 	c.Map[key] = val
+	if ok {
+		c.evicted(key, old, kv.Replaced)
+	}
 
 	c.Unlock()
 }
 
 func (c *Cache[K, V]) Add(key K, val V) error {
-	if c.Has(key) {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.Map[key]; ok {
 		return fmt.Errorf("ccmap: Data already exists with given key %T", key)
 	}
-	
-	c.Set(key, val)
+
+	c.Map[key] = val
 
 	return nil
 }
 
 func (c *Cache[K, V]) Update(key K, val V) error {
-	if !c.Has(key) {
+	c.Lock()
+	defer c.Unlock()
+
+	old, ok := c.Map[key]
+	if !ok {
 		return fmt.Errorf("ccmap: Data doesn't exists with given key %T", key)
 	}
 
-	c.Set(key, val)
+	c.Map[key] = val
+	c.evicted(key, old, kv.Replaced)
 
 	return nil
 }
 
-func (c *Cache[K, V]) Delete(key K) {
-	if c.Has(key) {
+// Deletes key and returns boolean if sucessful.
+func (c *Cache[K, V]) Delete(key K) (ok bool) {
+	c.Lock()
+
+	_, ok = c.Map[key]
+	delete(c.Map, key)
+
+	c.Unlock()
+	return
+}
+
+// Deletes key and returns boolean if sucessful, calling the eviction callbacks.
+func (c *Cache[K, V]) DeleteCallback(key K) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	val, ok := c.Map[key]
+	if ok {
 		delete(c.Map, key)
+		c.evicted(key, val, kv.Deleted)
+	}
+
+	return ok
+}
+
+// Gets the current amount of elements in the cache.
+func (c *Cache[K, V]) Count() (count int) {
+	c.RLock()
+
+	count = len(c.Map)
+
+	c.RUnlock()
+	return
+}
+
+// Clears the cache without calling OnEviction callback
+func (c *Cache[K, V]) Clear() {
+	c.Lock()
+
+	clear(c.Map)
+
+	c.Unlock()
+}
+
+// Calls f for every item until it returns false. The cache is read locked while f is called,
+// so f must not write to it.
+func (c *Cache[K, V]) Range(f func(key K, val V) bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	for k, v := range c.Map {
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+// Returns an iterator over the items for use with range, it works like Range so breaking
+// out of the loop stops it and the loop body must not write to the cache.
+func (c *Cache[K, V]) All() iter.Seq2[K, V] {
+	return c.Range
+}
+
+// Returns an iterator over the keys, see All.
+func (c *Cache[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		c.Range(func(key K, _ V) bool {
+			return yield(key)
+		})
+	}
+}
+
+// Returns an iterator over the values, see All.
+func (c *Cache[K, V]) Values() iter.Seq[V] {
+	return func(yield func(V) bool) {
+		c.Range(func(_ K, val V) bool {
+			return yield(val)
+		})
 	}
 }
 
 func (c *Cache[K, V]) Flush() {
+	c.Lock()
+
 	for k,v := range c.Map {
-		c.OnEvicted(k, v)
+		c.evicted(k, v, kv.Flushed)
 		delete(c.Map, k)
 	}
+
+	c.Unlock()
 }
 
 func (c *Cache[K, V]) LoadFromJSON(b []byte) (err error) {
@@ -3,6 +3,9 @@ package ccmap
 import (
 	"fmt"
 	"testing"
+
+	"github.com/saintwish/kv"
+	"github.com/saintwish/kv/kvtest"
 )
 
 func TestSetGet_KeyString(t *testing.T) {
@@ -23,6 +26,12 @@ func TestSetGet_KeyInt(t *testing.T) {
 	}
 }
 
+func TestConformance(t *testing.T) {
+	kvtest.Run(t, func() kv.Cache[string, int] {
+		return New[string, int]()
+	})
+}
+
 func TestFlush(t *testing.T) {
 	cache := New[int, string]()
 	cache.SetOnEvicted(func(k int, v string){
@@ -35,4 +44,29 @@ func TestFlush(t *testing.T) {
 	cache.Set(5465, "leet haxiors3")
 
 	cache.Flush()
-}
\ No newline at end of file
+}
+
+func TestEvictedReason(t *testing.T) {
+	cache := New[int, string]()
+	reasons := map[int][]kv.Reason{}
+	cache.SetOnEvictedReason(func(k int, v string, r kv.Reason) {
+		reasons[k] = append(reasons[k], r)
+	})
+
+	cache.Set(1, "one")
+	cache.Set(1, "uno")
+	cache.Set(2, "two")
+	cache.DeleteCallback(2)
+	cache.DeleteCallback(3)
+	cache.Flush()
+
+	if res := reasons[1]; len(res) != 2 || res[0] != kv.Replaced || res[1] != kv.Flushed {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", res, []kv.Reason{kv.Replaced, kv.Flushed})
+	}
+	if res := reasons[2]; len(res) != 1 || res[0] != kv.Deleted {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", res, []kv.Reason{kv.Deleted})
+	}
+	if res := reasons[3]; len(res) != 0 {
+		t.Errorf("Callback called for missing key, got: %v.", res)
+	}
+}
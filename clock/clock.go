@@ -0,0 +1,21 @@
+// Package clock lets the time based caches read the time from something other
+// than the system clock, mainly so their expiration can be tested without sleeping.
+package clock
+
+import (
+	"time"
+)
+
+// A source of the current time, must be safe for concurrent use.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// The system clock, used by default.
+var Real Clock = realClock{}
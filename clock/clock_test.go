@@ -0,0 +1,47 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCoarse(t *testing.T) {
+	c := NewCoarse(time.Millisecond)
+	defer c.Stop()
+
+	start := c.Now()
+	time.Sleep(20*time.Millisecond)
+	now := c.Now()
+
+	if d := now.Sub(start); d < 10*time.Millisecond || d > time.Second {
+		t.Errorf("Coarse clock moved %v, want about %v.", d, 20*time.Millisecond)
+	}
+	if lag := time.Since(now); lag < 0 || lag > time.Second {
+		t.Errorf("Coarse clock lags %v behind the real one.", lag)
+	}
+
+	c.Stop()
+	c.Stop()
+	stopped := c.Now()
+	time.Sleep(5*time.Millisecond)
+
+	if !c.Now().Equal(stopped) {
+		t.Errorf("Stopped clock kept moving")
+	}
+}
+
+func BenchmarkReal(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		Real.Now()
+	}
+}
+
+func BenchmarkCoarse(b *testing.B) {
+	c := NewCoarse(time.Millisecond)
+	defer c.Stop()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Now()
+	}
+}
@@ -0,0 +1,40 @@
+// Package clocktest provides a clock that only moves when told to, for testing
+// expiration deterministically and without sleeping.
+package clocktest
+
+import (
+	"sync"
+	"time"
+)
+
+// A clock.Clock that is advanced manually, safe for concurrent use.
+type Fake struct {
+	now time.Time
+	mu sync.Mutex
+}
+
+// Creates a fake clock reading now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+// Moves the clock forward by d.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	f.now = f.now.Add(d)
+	f.mu.Unlock()
+}
+
+// Sets the clock to now.
+func (f *Fake) Set(now time.Time) {
+	f.mu.Lock()
+	f.now = now
+	f.mu.Unlock()
+}
@@ -0,0 +1,68 @@
+package clock
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A clock that reads the system time once per resolution from a background goroutine,
+// so Now is a single atomic load instead of a call to time.Now.
+//
+// The tradeoff is precision, the time returned lags behind the real time by up to the
+// resolution plus however late the goroutine gets scheduled. Items expire that much late
+// and TTLs shorter than the resolution aren't meaningful. The times keep a monotonic
+// reading so wall clock changes don't affect expirations.
+//
+// Stop must be called once the clock isn't used anymore.
+type Coarse struct {
+	base time.Time
+	offset atomic.Int64 //time passed since base when last updated
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// Creates a coarse clock updated every resolution, such as a millisecond.
+func NewCoarse(resolution time.Duration) *Coarse {
+	if resolution <= 0 {
+		panic("clock: resolution must be greater than zero!")
+	}
+
+	c := &Coarse{
+		base: time.Now(),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go c.run(resolution)
+
+	return c
+}
+
+func (c *Coarse) run(resolution time.Duration) {
+	ticker := time.NewTicker(resolution)
+	defer ticker.Stop()
+	defer close(c.done)
+
+	for {
+		select {
+		case <-ticker.C:
+			c.offset.Store(int64(time.Since(c.base)))
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *Coarse) Now() time.Time {
+	return c.base.Add(time.Duration(c.offset.Load()))
+}
+
+// Stops updating the clock, Now keeps returning the last time read.
+func (c *Coarse) Stop() {
+	c.once.Do(func() {
+		close(c.stop)
+	})
+	<-c.done
+}
@@ -0,0 +1,10 @@
+package kv
+
+// Tells Compute what to do with the value its function returned.
+type ComputeOp uint8
+
+const (
+	StoreOp ComputeOp = iota //sets the key to the returned value
+	DeleteOp //deletes the key if it exists
+	CancelOp //leaves the key as it was
+)
@@ -1,5 +1,5 @@
 module github.com/saintwish/kv
 
-go 1.21.0
+go 1.23.0
 
 require github.com/dolthub/maphash v0.1.0
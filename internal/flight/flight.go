@@ -0,0 +1,81 @@
+// Package flight makes concurrent loads of the same key share a single call.
+package flight
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+type call[V any] struct {
+	done chan struct{}
+	val V
+	err error
+}
+
+// Deduplicates calls by key, the zero value is ready to use.
+type Group[K comparable, V any] struct {
+	mu sync.Mutex
+	calls map[K]*call[V]
+}
+
+// Runs fn once for all concurrent callers with the same key and waits for its result, or until ctx is done.
+//
+// fn runs in its own goroutine with a context that keeps the values of ctx but isn't
+// cancelled with it, so one caller giving up doesn't fail the load for everyone else.
+// A panic in fn is returned as an error.
+func (g *Group[K, V]) Do(ctx context.Context, key K, fn func(ctx context.Context) (V, error)) (val V, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	c := g.start(ctx, key, fn)
+
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		return val, ctx.Err()
+	}
+}
+
+// Starts fn like Do unless a call for key is already running, without waiting for it.
+func (g *Group[K, V]) Go(ctx context.Context, key K, fn func(ctx context.Context) (V, error)) {
+	g.start(ctx, key, fn)
+}
+
+func (g *Group[K, V]) start(ctx context.Context, key K, fn func(ctx context.Context) (V, error)) *call[V] {
+	g.mu.Lock()
+
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		return c
+	}
+
+	if g.calls == nil {
+		g.calls = make(map[K]*call[V])
+	}
+
+	c := &call[V]{done: make(chan struct{})}
+	g.calls[key] = c
+
+	g.mu.Unlock()
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				c.err = fmt.Errorf("kv: loader panicked: %v", r)
+			}
+
+			g.mu.Lock()
+			delete(g.calls, key)
+			g.mu.Unlock()
+
+			close(c.done)
+		}()
+
+		c.val, c.err = fn(context.WithoutCancel(ctx))
+	}()
+
+	return c
+}
@@ -0,0 +1,191 @@
+// Package list implements doubly linked lists whose nodes live in a shared
+// slice and are addressed by index. Nodes never move and hold no pointers, so
+// an index can be stored next to a cached value and used for O(1) removal
+// without adding work for the GC.
+package list
+
+// Index 0 is never handed out and marks the absence of a node, so the zero
+// value of an index or a List can be used as is.
+type node[T any] struct {
+	Value T
+	prev, next int32
+}
+
+// A list of nodes stored in an Arena, the zero value is an empty list.
+type List struct {
+	head, tail int32
+	len int
+}
+
+// Returns the number of nodes in the list.
+func (l *List) Len() int {
+	return l.len
+}
+
+// Holds the nodes of any number of lists.
+type Arena[T any] struct {
+	nodes []node[T]
+	free int32 //head of the free list, chained through next
+}
+
+func NewArena[T any](sz int) *Arena[T] {
+	a := &Arena[T]{
+		nodes: make([]node[T], 1, sz+1),
+	}
+
+	return a
+}
+
+// Returns a pointer to the value of node i, only valid until the next node is allocated.
+func (a *Arena[T]) Value(i int32) *T {
+	return &a.nodes[i].Value
+}
+
+func (a *Arena[T]) Next(i int32) int32 {
+	return a.nodes[i].next
+}
+
+func (a *Arena[T]) Prev(i int32) int32 {
+	return a.nodes[i].prev
+}
+
+// Returns the first node of l, or 0 if it's empty.
+func (a *Arena[T]) Front(l *List) int32 {
+	return l.head
+}
+
+// Returns the last node of l, or 0 if it's empty.
+func (a *Arena[T]) Back(l *List) int32 {
+	return l.tail
+}
+
+func (a *Arena[T]) alloc(val T) (i int32) {
+	if a.free != 0 {
+		i = a.free
+		a.free = a.nodes[i].next
+		a.nodes[i] = node[T]{Value: val}
+		return
+	}
+
+	a.nodes = append(a.nodes, node[T]{Value: val})
+	return int32(len(a.nodes)-1)
+}
+
+// Appends val to l and returns the index of its node.
+func (a *Arena[T]) PushBack(l *List, val T) int32 {
+	i := a.alloc(val)
+	a.LinkBack(l, i)
+	return i
+}
+
+// Prepends val to l and returns the index of its node.
+func (a *Arena[T]) PushFront(l *List, val T) int32 {
+	i := a.alloc(val)
+	a.LinkFront(l, i)
+	return i
+}
+
+// Links the unlinked node i at the back of l.
+func (a *Arena[T]) LinkBack(l *List, i int32) {
+	n := &a.nodes[i]
+	n.prev, n.next = l.tail, 0
+
+	if l.tail != 0 {
+		a.nodes[l.tail].next = i
+	} else {
+		l.head = i
+	}
+
+	l.tail = i
+	l.len++
+}
+
+// Links the unlinked node i at the front of l.
+func (a *Arena[T]) LinkFront(l *List, i int32) {
+	n := &a.nodes[i]
+	n.prev, n.next = 0, l.head
+
+	if l.head != 0 {
+		a.nodes[l.head].prev = i
+	} else {
+		l.tail = i
+	}
+
+	l.head = i
+	l.len++
+}
+
+// Unlinks node i from l without freeing it, so it can be linked into another list.
+func (a *Arena[T]) Unlink(l *List, i int32) {
+	n := &a.nodes[i]
+
+	if n.prev != 0 {
+		a.nodes[n.prev].next = n.next
+	} else {
+		l.head = n.next
+	}
+
+	if n.next != 0 {
+		a.nodes[n.next].prev = n.prev
+	} else {
+		l.tail = n.prev
+	}
+
+	n.prev, n.next = 0, 0
+	l.len--
+}
+
+// Removes node i from l, frees it and returns its value.
+func (a *Arena[T]) Remove(l *List, i int32) (val T) {
+	a.Unlink(l, i)
+
+	var zero T
+	val, a.nodes[i].Value = a.nodes[i].Value, zero
+	a.nodes[i].next = a.free
+	a.free = i
+
+	return
+}
+
+func (a *Arena[T]) MoveToBack(l *List, i int32) {
+	if l.tail == i {
+		return
+	}
+
+	a.Unlink(l, i)
+	a.LinkBack(l, i)
+}
+
+func (a *Arena[T]) MoveToFront(l *List, i int32) {
+	if l.head == i {
+		return
+	}
+
+	a.Unlink(l, i)
+	a.LinkFront(l, i)
+}
+
+// Moves every node of src to the back of dst, leaving src empty.
+func (a *Arena[T]) Splice(dst, src *List) {
+	if src.len == 0 {
+		return
+	}
+
+	if dst.tail != 0 {
+		a.nodes[dst.tail].next = src.head
+		a.nodes[src.head].prev = dst.tail
+	} else {
+		dst.head = src.head
+	}
+
+	dst.tail = src.tail
+	dst.len += src.len
+	*src = List{}
+}
+
+// Frees every node, all lists using the arena must be reset by the caller.
+func (a *Arena[T]) Clear() {
+	clear(a.nodes)
+	a.nodes = a.nodes[:1]
+	a.free = 0
+}
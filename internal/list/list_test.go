@@ -0,0 +1,60 @@
+package list
+
+import (
+	"slices"
+	"testing"
+)
+
+func values(a *Arena[int], l *List) (vals []int) {
+	for i := a.Front(l); i != 0; i = a.Next(i) {
+		vals = append(vals, *a.Value(i))
+	}
+	return
+}
+
+func TestPushRemove(t *testing.T) {
+	a := NewArena[int](8)
+	var l List
+
+	one := a.PushBack(&l, 1)
+	two := a.PushBack(&l, 2)
+	a.PushBack(&l, 3)
+	a.PushFront(&l, 0)
+
+	if res := values(a, &l); !slices.Equal(res, []int{0, 1, 2, 3}) {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", res, []int{0, 1, 2, 3})
+	}
+
+	a.MoveToBack(&l, one)
+	a.Remove(&l, two)
+
+	if res := values(a, &l); !slices.Equal(res, []int{0, 3, 1}) {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", res, []int{0, 3, 1})
+	}
+
+	//freed node gets reused and the old index of one stays valid
+	if i := a.PushBack(&l, 4); i != two {
+		t.Errorf("Freed node was not reused, got: %d, want: %d.", i, two)
+	}
+	if v := *a.Value(one); v != 1 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", v, 1)
+	}
+}
+
+func TestSplice(t *testing.T) {
+	a := NewArena[int](8)
+	var l1, l2 List
+
+	a.PushBack(&l1, 1)
+	a.PushBack(&l2, 2)
+	a.PushBack(&l2, 3)
+
+	a.Splice(&l1, &l2)
+
+	if res := values(a, &l1); !slices.Equal(res, []int{1, 2, 3}) || l1.Len() != 3 {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", res, []int{1, 2, 3})
+	}
+	if l2.Len() != 0 || a.Front(&l2) != 0 {
+		t.Errorf("Spliced list should be empty")
+	}
+}
@@ -0,0 +1,10 @@
+package kv
+
+// Picks how ForEachMode visits the items of a cache. Either way shards are visited one at a
+// time, so the walk is weakly consistent and never locks the whole cache.
+type IterMode uint8
+
+const (
+	IterLocked IterMode = iota //the callback runs under the shard's read lock and must not write to the cache
+	IterCopy //the shard is copied under its read lock and the callback runs with no lock held
+)
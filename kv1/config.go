@@ -0,0 +1,90 @@
+package kv1
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dolthub/maphash"
+	"github.com/saintwish/kv"
+	"github.com/saintwish/kv/clock"
+)
+
+// Settings of a cache made by NewFromConfig, only Capacity and Shards are required.
+type Config[K comparable, V any] struct {
+	Capacity uint64 //size of the cache, split evenly between the shards
+	Shards uint64 //number of shards, can't be more than Capacity
+	Expiration time.Duration //default expiration of items, NoExpiration or zero for none
+	JanitorInterval time.Duration //starts a janitor sweeping every shard this often if set, see NewWithJanitor
+	RefreshAfter time.Duration //see SetRefreshAfter, must be shorter than Expiration
+	StaleIfError time.Duration //see SetStaleIfError
+	EvictOnAccess bool //see Cache.EvictOnAccess
+
+	OnEvicted func(K, V)
+	OnEvictedReason func(K, V, kv.Reason)
+	Loader kv.Loader[K, V]
+
+	Hasher func(K) uint64 //picks the shard of keys, a randomly seeded maphash by default
+	Clock clock.Clock //clock.Real by default
+	Metrics kv.Metrics //told about hits, misses and evictions if set
+}
+
+func (cfg *Config[K, V]) validate() error {
+	switch {
+	case cfg.Shards == 0:
+		return fmt.Errorf("kv1: shard count must be greater than zero: %w", kv.ErrInvalidConfig)
+	case cfg.Shards > cfg.Capacity:
+		return fmt.Errorf("kv1: shard count must be smaller than cache size: %w", kv.ErrInvalidConfig)
+	case cfg.Expiration < 0 && cfg.Expiration != NoExpiration:
+		return fmt.Errorf("kv1: expiration can't be negative: %w", kv.ErrInvalidConfig)
+	case cfg.JanitorInterval < 0:
+		return fmt.Errorf("kv1: janitor interval can't be negative: %w", kv.ErrInvalidConfig)
+	case cfg.RefreshAfter < 0 || cfg.StaleIfError < 0:
+		return fmt.Errorf("kv1: refresh after and stale if error can't be negative: %w", kv.ErrInvalidConfig)
+	case cfg.RefreshAfter > 0 && cfg.Expiration > 0 && cfg.RefreshAfter >= cfg.Expiration:
+		return fmt.Errorf("kv1: refresh after must be shorter than expiration: %w", kv.ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// Creates a cache from cfg, returning an error wrapping kv.ErrInvalidConfig instead of
+// panicking if it's invalid.
+func NewFromConfig[K comparable, V any](cfg Config[K, V]) (*Cache[K, V], error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real
+	}
+
+	if cfg.Hasher == nil {
+		cfg.Hasher = maphash.NewHasher[K]().Hash
+	}
+
+	cache := &Cache[K, V] {
+		shards: make([]*shard[K, V], cfg.Shards),
+		shardCount: cfg.Shards,
+		hash: cfg.Hasher,
+		tb: newTimebase(cfg.Clock),
+		metrics: cfg.Metrics,
+		refreshAfter: cfg.RefreshAfter,
+		staleIfError: cfg.StaleIfError,
+
+		OnEvicted: cfg.OnEvicted,
+		OnEvictedReason: cfg.OnEvictedReason,
+		EvictOnAccess: cfg.EvictOnAccess,
+		Loader: cfg.Loader,
+	}
+
+	for i := 0; i < int(cfg.Shards); i++ {
+		cache.shards[i] = newShard[K, V](cfg.Expiration, cfg.Capacity, cfg.Shards, cache.tb)
+		cache.shards[i].grace = cfg.StaleIfError
+	}
+
+	if cfg.JanitorInterval > 0 {
+		cache.startJanitor(cfg.JanitorInterval)
+	}
+
+	return cache, nil
+}
@@ -0,0 +1,45 @@
+package kv1
+
+import (
+	"sync"
+	"time"
+)
+
+//used internally, sweeps expired items of a cache in the background.
+type janitor struct {
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func newJanitor() *janitor {
+	return &janitor{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Sweeps one shard every step, going round robin over all of them so the
+// shards never get locked all at once.
+func (j *janitor) run(step time.Duration, count int, sweep func(i int)) {
+	ticker := time.NewTicker(step)
+	defer ticker.Stop()
+	defer close(j.done)
+
+	for i := 0; ; i = (i+1) % count {
+		select {
+		case <-ticker.C:
+			sweep(i)
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+// Stops the janitor and waits for it to exit, safe to call more than once.
+func (j *janitor) close() {
+	j.once.Do(func() {
+		close(j.stop)
+	})
+	<-j.done
+}
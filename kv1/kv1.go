@@ -1,125 +1,514 @@
 package kv1
 
 import (
+	"context"
 	"fmt"
+	"iter"
 	"time"
 	
-	"github.com/dolthub/maphash"
+	"github.com/saintwish/kv"
+	"github.com/saintwish/kv/clock"
+	"github.com/saintwish/kv/internal/flight"
 )
 
 type Cache[K comparable, V any] struct {
 	shards []*shard[K, V]
 	shardCount uint64
-	hash maphash.Hasher[K]
+	hash func(K) uint64
+	janitor *janitor
+	tb *timebase
+	metrics kv.Metrics
 
 	OnEvicted func(K, V) //function that's called when cached item is deleted by the system
+	OnEvictedReason func(K, V, kv.Reason) //same as OnEvicted but also told why, called for replaced items too
+	EvictOnAccess bool //deletes expired items found by reads right away instead of waiting for a sweep
+	Loader kv.Loader[K, V] //function that's called by GetOrLoad to load missing items
+
+	flight flight.Group[K, V]
+	refreshAfter time.Duration //age after which GetOrLoad reloads items in the background
+	staleIfError time.Duration //how long after expiring GetOrLoad may still return an item when Loader fails
 }
 
+var _ kv.Cache[string, any] = (*Cache[string, any])(nil)
+
+// Creates a cache where items expire after ex by default, NoExpiration (or zero) means items
+// only expire when given their own TTL. Panics if the shard count is bigger than the size,
+// use NewFromConfig to get an error instead.
 func New[K comparable, V any](ex time.Duration, sz uint64, sc uint64) *Cache[K, V] {
-	if sc > sz {
-		panic("kv1: shard count must be smaller than cache size!")
+	return mustNew(NewFromConfig(Config[K, V]{
+		Capacity: sz,
+		Shards: sc,
+		Expiration: ex,
+	}))
+}
+
+// Creates a cache that reads the time from clk rather than the system clock.
+func NewWithClock[K comparable, V any](ex time.Duration, sz uint64, sc uint64, clk clock.Clock) *Cache[K, V] {
+	return mustNew(NewFromConfig(Config[K, V]{
+		Capacity: sz,
+		Shards: sc,
+		Expiration: ex,
+		Clock: clk,
+	}))
+}
+
+// Creates a cache with a background janitor that deletes expired items.
+// Every shard is swept once per interval, with the sweeps spread evenly over the
+// interval so only one shard is locked at a time. Call Close to stop the janitor.
+func NewWithJanitor[K comparable, V any](ex time.Duration, sz uint64, sc uint64, interval time.Duration) *Cache[K, V] {
+	if interval <= 0 {
+		panic("kv1: janitor interval must be greater than zero!")
 	}
 
-	cache := Cache[K, V] {}
-	cache.shards = make([]*shard[K, V], sc)
-	cache.hash = maphash.NewHasher[K]()
-	cache.shardCount = sc
+	return mustNew(NewFromConfig(Config[K, V]{
+		Capacity: sz,
+		Shards: sc,
+		Expiration: ex,
+		JanitorInterval: interval,
+	}))
+}
+
+func mustNew[K comparable, V any](cache *Cache[K, V], err error) *Cache[K, V] {
+	if err != nil {
+		panic(err)
+	}
+
+	return cache
+}
 
-	for i := 0; i < int(sc); i++ {
-		cache.shards[i] = newShard[K, V](ex, sz, sc)
+func (c *Cache[K, V]) startJanitor(interval time.Duration) {
+	step := interval / time.Duration(c.shardCount)
+	if step <= 0 {
+		step = time.Nanosecond
 	}
 
-	return &cache
+	c.janitor = newJanitor()
+	go c.janitor.run(step, len(c.shards), func(i int) {
+		c.shards[i].evictExpired(c.evicted)
+	})
+}
+
+// Stops the background janitor if there is one and waits for it to exit.
+// The cache can still be used afterwards, expired items just won't be swept anymore.
+func (c *Cache[K, V]) Close() {
+	if c.janitor != nil {
+		c.janitor.close()
+	}
 }
 
 func (c *Cache[K, V]) getShardIndex(key K) uint64 {
-	sum := c.hash.Hash(key)
+	sum := c.hash(key)
 
 	return sum % c.shardCount
 }
 
 func (c *Cache[K, V]) getShard(key K) *shard[K,V] {
-	sum := c.hash.Hash(key)
+	sum := c.hash(key)
 	return c.shards[sum%c.shardCount]
 }
 
+// Tells the metrics if a read was a hit.
+func (c *Cache[K, V]) record(hit bool) {
+	if c.metrics == nil {
+		return
+	}
+
+	if hit {
+		c.metrics.Hit()
+	} else {
+		c.metrics.Miss()
+	}
+}
+
 func (c *Cache[K, V]) SetOnEvicted(f func(K, V)) {
 	c.OnEvicted = f
 }
 
+func (c *Cache[K, V]) SetOnEvictedReason(f func(K, V, kv.Reason)) {
+	c.OnEvictedReason = f
+}
+
+func (c *Cache[K, V]) SetLoader(f kv.Loader[K, V]) {
+	c.Loader = f
+}
+
+// Makes GetOrLoad return items older than d right away while reloading them in the background,
+// so hot items get refreshed before they expire. d should be shorter than the items' TTL, zero disables it.
+func (c *Cache[K, V]) SetRefreshAfter(d time.Duration) {
+	c.refreshAfter = d
+}
+
+// Makes GetOrLoad return an item that expired less than d ago when Loader fails to reload it.
+// Expired items are kept for d to allow this but stay invisible to every other read,
+// it only applies to items set after the call.
+func (c *Cache[K, V]) SetStaleIfError(d time.Duration) {
+	c.staleIfError = d
+
+	for i := 0; i < len(c.shards); i++ {
+		shard := c.shards[i]
+		shard.Lock()
+		shard.grace = d
+		shard.Unlock()
+	}
+}
+
+// Calls the eviction callbacks, OnEvicted keeps its old behaviour of not being called for replaced items.
+func (c *Cache[K, V]) evicted(key K, val V, reason kv.Reason) {
+	if c.metrics != nil {
+		c.metrics.Evict(reason)
+	}
+
+	if c.OnEvictedReason != nil {
+		c.OnEvictedReason(key, val, reason)
+	}
+
+	if c.OnEvicted != nil && reason != kv.Replaced {
+		c.OnEvicted(key, val)
+	}
+}
+
+// Called by reads that found an expired item.
+func (c *Cache[K, V]) expiredOnAccess(shard *shard[K, V], key K) {
+	if c.EvictOnAccess {
+		shard.evictItem(key, c.evicted)
+	}
+}
+
+// Gets the value of key, expired items are treated as missing.
 func (c *Cache[K, V]) Get(key K) V {
-	shard := c.getShard(key)
-	return shard.get(key)
+	val, _ := c.GetHas(key)
+	return val
 }
 
+// Gets the value of key and renews its expiration.
 func (c *Cache[K, V]) GetRenew(key K) V {
-	shard := c.getShard(key)
-	return shard.getRenew(key)
+	val, _ := c.GetHasRenew(key)
+	return val
 }
 
+// Gets the value of key and if it exists, expired items are treated as missing.
 func (c *Cache[K, V]) GetHas(key K) (V, bool) {
 	shard := c.getShard(key)
-	return shard.getHas(key)
+	val, ok, ex := shard.getHas(key)
+	if ex {
+		c.expiredOnAccess(shard, key)
+	}
+	c.record(ok)
+
+	return val, ok
 }
 
+// Gets the value of key and if it exists, renewing its expiration if it does.
 func (c *Cache[K, V]) GetHasRenew(key K) (V, bool) {
 	shard := c.getShard(key)
-	return shard.getHasRenew(key)
+	val, ok, ex := shard.getHasRenew(key)
+	if ex {
+		c.expiredOnAccess(shard, key)
+	}
+	c.record(ok)
+
+	return val, ok
 }
 
+// Checks if key exists, expired items are treated as missing.
 func (c *Cache[K, V]) Has(key K) bool {
 	shard := c.getShard(key)
-	return shard.has(key)
+	_, ok, ex := shard.getHas(key)
+	if ex {
+		c.expiredOnAccess(shard, key)
+	}
+
+	return ok
+}
+
+// Gets the value of key, calling Loader to load and cache it if it's missing. Concurrent
+// misses of the same key share a single call of Loader and its errors are returned without
+// caching anything. If ctx is done first its error is returned, the load carries on for the
+// other callers and still gets cached.
+//
+// See SetRefreshAfter and SetStaleIfError for serving items while they get reloaded.
+func (c *Cache[K, V]) GetOrLoad(ctx context.Context, key K) (V, error) {
+	now := c.tb.now()
+	shard := c.getShard(key)
+	itm, found := shard.getItem(key)
+	c.record(found && !itm.expired(now))
+
+	if found && !itm.expired(now) {
+		if c.refreshAfter > 0 && c.Loader != nil && itm.refreshDue(now, c.refreshAfter) {
+			c.flight.Go(ctx, key, c.reload(key))
+		}
+
+		return itm.Object, nil
+	}
+
+	if c.Loader == nil {
+		var val V
+		return val, kv.ErrNoLoader
+	}
+
+	val, err := c.flight.Do(ctx, key, func(ctx context.Context) (V, error) {
+		//another load may have finished between the miss and this one starting.
+		if val, ok, _ := shard.getHas(key); ok {
+			return val, nil
+		}
+
+		return c.reload(key)(ctx)
+	})
+
+	if err != nil && ctx.Err() == nil && found && c.staleIfError > 0 && !itm.expired(now - int64(c.staleIfError)) {
+		return itm.Object, nil
+	}
+
+	return val, err
+}
+
+// Returns a function loading key with Loader and caching it.
+func (c *Cache[K, V]) reload(key K) func(ctx context.Context) (V, error) {
+	return func(ctx context.Context) (V, error) {
+		val, err := c.Loader(ctx, key)
+		if err == nil {
+			c.Set(key, val)
+		}
+
+		return val, err
+	}
 }
 
 // Sets the key with value, will overwrite if key exists
 func (c *Cache[K, V]) Set(key K, val V) {
+	c.SetWithTTL(key, val, DefaultExpiration)
+}
+
+// Sets the key with value expiring after ttl, will overwrite if key exists.
+// Use DefaultExpiration for the cache's expiration or NoExpiration for an item that never expires.
+// Any other negative ttl sets an item that's already expired, so it's never returned and gets
+// evicted with kv.Expired like any other.
+func (c *Cache[K, V]) SetWithTTL(key K, val V, ttl time.Duration) {
 	shard := c.getShard(key)
-	shard.set(key, val)
+	shard.set(key, shard.newItem(val, ttl), c.evicted)
+}
+
+// Sets the key with value expiring at deadline, will overwrite if key exists.
+// A zero deadline means the item never expires.
+func (c *Cache[K, V]) SetExpireAt(key K, val V, deadline time.Time) {
+	shard := c.getShard(key)
+	shard.set(key, shard.newItemAt(val, deadline), c.evicted)
 }
 
 // Adds key with value to map, will error if key already exists.
 func (c *Cache[K, V]) Add(key K, val V) error {
+	return c.AddWithTTL(key, val, DefaultExpiration)
+}
+
+// Adds key with value expiring after ttl, will error if key already exists.
+func (c *Cache[K, V]) AddWithTTL(key K, val V, ttl time.Duration) error {
 	shard := c.getShard(key)
-	if shard.has(key) {
+	if !shard.add(key, shard.newItem(val, ttl), c.evicted) {
+		return fmt.Errorf("kv1: Data already exists with given key %T", key)
+	}
+
+	return nil
+}
+
+// Adds key with value expiring at deadline, will error if key already exists.
+func (c *Cache[K, V]) AddExpireAt(key K, val V, deadline time.Time) error {
+	shard := c.getShard(key)
+	if !shard.add(key, shard.newItemAt(val, deadline), c.evicted) {
 		return fmt.Errorf("kv1: Data already exists with given key %T", key)
 	}
 
-	shard.set(key, val)
 	return nil
 }
 
 // Updates given key, errors if key doesn't already exists.
 func (c *Cache[K, V]) Update(key K, val V) error {
+	return c.UpdateWithTTL(key, val, DefaultExpiration)
+}
+
+// Updates given key with value expiring after ttl, errors if key doesn't already exists.
+func (c *Cache[K, V]) UpdateWithTTL(key K, val V, ttl time.Duration) error {
 	shard := c.getShard(key)
-	if !shard.has(key) {
+	if !shard.update(key, shard.newItem(val, ttl), c.evicted) {
 		return fmt.Errorf("kv1: Data doesn't exists with given key %T", key)
 	}
 
-	shard.update(key, val)
 	return nil
 }
 
-// Will Set or Update said key depending if exists or not.
-func (c *Cache[K, V]) SetOrUpdate(key K, val V) {
+// Updates given key with value expiring at deadline, errors if key doesn't already exists.
+func (c *Cache[K, V]) UpdateExpireAt(key K, val V, deadline time.Time) error {
 	shard := c.getShard(key)
-	if shard.has(key) {
-		shard.update(key, val)
-	}else{
-		shard.set(key, val)
+	if !shard.update(key, shard.newItemAt(val, deadline), c.evicted) {
+		return fmt.Errorf("kv1: Data doesn't exists with given key %T", key)
 	}
+
+	return nil
+}
+
+// Will Set or Update said key depending if exists or not, in a single step.
+func (c *Cache[K, V]) SetOrUpdate(key K, val V) {
+	shard := c.getShard(key)
+	shard.set(key, shard.newItem(val, DefaultExpiration), c.evicted)
+}
+
+// Calls f with the current value of key, or the zero value and false if it's missing, and
+// applies the returned op. The shard of key stays locked while f runs, so f must be quick
+// and must not use the cache. Returns the value of key afterwards and if it exists.
+func (c *Cache[K, V]) Compute(key K, f func(old V, exists bool) (V, kv.ComputeOp)) (V, bool) {
+	shard := c.getShard(key)
+	return shard.compute(key, f, c.evicted)
+}
+
+// Sets key to the value returned by f if it's missing, f being called under the shard lock.
+// Returns the value of key and true if it already existed.
+func (c *Cache[K, V]) ComputeIfAbsent(key K, f func() V) (actual V, loaded bool) {
+	actual, _ = c.Compute(key, func(old V, exists bool) (V, kv.ComputeOp) {
+		if loaded = exists; exists {
+			return old, kv.CancelOp
+		}
+
+		return f(), kv.StoreOp
+	})
+
+	return
+}
+
+// Gets the value of key if it exists, otherwise sets it to val. Loaded is true if the value
+// was already there.
+func (c *Cache[K, V]) LoadOrStore(key K, val V) (actual V, loaded bool) {
+	return c.ComputeIfAbsent(key, func() V {
+		return val
+	})
+}
+
+// Deletes key, returning its value and if it existed.
+func (c *Cache[K, V]) LoadAndDelete(key K) (val V, loaded bool) {
+	c.Compute(key, func(old V, exists bool) (V, kv.ComputeOp) {
+		val, loaded = old, exists
+		return old, kv.DeleteOp
+	})
+
+	return
+}
+
+// Sets key to new if its value is equal to old, panics like sync.Map if V isn't comparable.
+func (c *Cache[K, V]) CompareAndSwap(key K, old V, new V) (swapped bool) {
+	c.Compute(key, func(cur V, exists bool) (V, kv.ComputeOp) {
+		if swapped = exists && any(cur) == any(old); swapped {
+			return new, kv.StoreOp
+		}
+
+		return cur, kv.CancelOp
+	})
+
+	return
 }
 
+// Deletes key if its value is equal to old, panics like sync.Map if V isn't comparable.
+func (c *Cache[K, V]) CompareAndDelete(key K, old V) (deleted bool) {
+	c.Compute(key, func(cur V, exists bool) (V, kv.ComputeOp) {
+		if deleted = exists && any(cur) == any(old); deleted {
+			return cur, kv.DeleteOp
+		}
+
+		return cur, kv.CancelOp
+	})
+
+	return
+}
+
+// Renews the expiration of key by the TTL it was set with.
 func (c *Cache[K, V]) Renew(key K) {
 	shard := c.getShard(key)
 	shard.renew(key)
 }
 
+// Gives key a new expiration ttl from now, which renewing it will use from then on.
+// Takes DefaultExpiration and NoExpiration like SetWithTTL, returns false if key doesn't exist.
+func (c *Cache[K, V]) ChangeTTL(key K, ttl time.Duration) bool {
+	shard := c.getShard(key)
+	return shard.setExpire(key, func(val V) item[V] {
+		return shard.newItem(val, ttl)
+	})
+}
+
+// Gives key a new expiration at deadline, a zero deadline means it never expires.
+// Returns false if key doesn't exist.
+func (c *Cache[K, V]) ChangeExpireAt(key K, deadline time.Time) bool {
+	shard := c.getShard(key)
+	return shard.setExpire(key, func(val V) item[V] {
+		return shard.newItemAt(val, deadline)
+	})
+}
+
+// Gets the item of key if it exists and isn't expired.
+func (c *Cache[K, V]) lookup(key K) (item[V], bool) {
+	shard := c.getShard(key)
+	itm, ok := shard.getItem(key)
+	if ok && itm.expired(c.tb.now()) {
+		c.expiredOnAccess(shard, key)
+		return itm, false
+	}
+
+	return itm, ok
+}
+
+func (c *Cache[K, V]) expireTime(itm item[V]) time.Time {
+	if itm.expire == never {
+		return time.Time{}
+	}
+
+	return c.tb.toTime(itm.expire)
+}
+
+// Gets the value of key along with when it expires, the time is zero if it never expires.
+func (c *Cache[K, V]) GetWithExpiration(key K) (V, time.Time, bool) {
+	itm, ok := c.lookup(key)
+	c.record(ok)
+	if !ok {
+		var val V
+		return val, time.Time{}, false
+	}
+
+	return itm.Object, c.expireTime(itm), true
+}
+
+// Gets when key expires, the time is zero if it never expires.
+func (c *Cache[K, V]) ExpiresAt(key K) (time.Time, bool) {
+	itm, ok := c.lookup(key)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return c.expireTime(itm), true
+}
+
+// Gets how long key has left before expiring, NoExpiration if it never expires.
+func (c *Cache[K, V]) TTL(key K) (time.Duration, bool) {
+	itm, ok := c.lookup(key)
+	if !ok {
+		return 0, false
+	}
+
+	if itm.expire == never {
+		return NoExpiration, true
+	}
+
+	return time.Duration(itm.expire - c.tb.now()), true
+}
+
+// Deletes key and returns boolean if sucessful.
 func (c *Cache[K, V]) Delete(key K) bool {
 	shard := c.getShard(key)
 	return shard.delete(key)
 }
 
+// Deletes key and returns boolean if sucessful, calling the eviction callbacks.
+func (c *Cache[K, V]) DeleteCallback(key K) bool {
+	shard := c.getShard(key)
+	return shard.deleteCallback(key, c.evicted)
+}
+
 func (c *Cache[K, V]) ShardCount() uint64 {
 	return c.shardCount
 }
@@ -139,11 +528,13 @@ func (c *Cache[K, V]) GetShardCapacity(key K) int {
 	return shard.Map.Capacity()
 }
 
-// Gets the current amount of elements in the cache.
+// Gets the current amount of elements in the cache, including expired ones that haven't been deleted yet.
 func (c *Cache[K, V]) Count() (count int) {
 	for i := 0; i < len(c.shards); i++ {
 		shard := c.shards[i]
+		shard.RLock()
 		count = count + shard.Map.Count()
+		shard.RUnlock()
 	}
 	return
 }
@@ -152,7 +543,7 @@ func (c *Cache[K, V]) Count() (count int) {
 func (c *Cache[K, V]) Flush() {
 	for i := 0; i < len(c.shards); i++ {
 		shard := c.shards[i]
-		shard.flush(c.OnEvicted)
+		shard.flush(c.evicted)
 	}
 }
 
@@ -164,20 +555,69 @@ func (c *Cache[K, V]) Clear() {
 	}
 }
 
-func (c *Cache[K, V]) ForEach(f func(key K, val item[V])) {
-	for i := 0; i < len(c.shards); i++ {
-		shard := c.shards[i]
-		shard.Lock()
-		defer shard.Unlock()
+// Calls f for every item until it returns false, see kv.IterMode for how shards are locked.
+func (c *Cache[K, V]) ForEachMode(mode kv.IterMode, f func(key K, val V) bool) {
+	if mode == kv.IterLocked {
+		c.Range(f)
+		return
+	}
 
-		shard.Map.Iter(func(key K, val item[V]) (stop bool) {
-			f(key, val)
+	c.forEachCopy(func(key K, val item[V]) bool {
+		return f(key, val.Object)
+	})
+}
 
-			if stop {
+// Calls f for every item that isn't expired, copying one shard at a time so f may use the cache.
+func (c *Cache[K, V]) ForEach(f func(key K, val item[V])) {
+	c.forEachCopy(func(key K, val item[V]) bool {
+		f(key, val)
+		return true
+	})
+}
+
+func (c *Cache[K, V]) forEachCopy(f func(key K, val item[V]) bool) {
+	var keys []K
+	var vals []item[V]
+	for i := 0; i < len(c.shards); i++ {
+		keys, vals = c.shards[i].collect(keys[:0], vals[:0])
+		for j := range keys {
+			if !f(keys[j], vals[j]) {
 				return
 			}
+		}
+	}
+}
 
+// Calls f for every item until it returns false, skipping expired ones. Shards are read locked
+// one at a time while f is called for their items, so f must not write to the cache.
+func (c *Cache[K, V]) Range(f func(key K, val V) bool) {
+	for i := 0; i < len(c.shards); i++ {
+		if !c.shards[i].iterate(f) {
 			return
+		}
+	}
+}
+
+// Returns an iterator over the items for use with range, it works like Range so breaking
+// out of the loop stops it and the loop body must not write to the cache.
+func (c *Cache[K, V]) All() iter.Seq2[K, V] {
+	return c.Range
+}
+
+// Returns an iterator over the keys, see All.
+func (c *Cache[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		c.Range(func(key K, _ V) bool {
+			return yield(key)
+		})
+	}
+}
+
+// Returns an iterator over the values, see All.
+func (c *Cache[K, V]) Values() iter.Seq[V] {
+	return func(yield func(V) bool) {
+		c.Range(func(_ K, val V) bool {
+			return yield(val)
 		})
 	}
 }
@@ -185,11 +625,12 @@ func (c *Cache[K, V]) ForEach(f func(key K, val item[V])) {
 func (c *Cache[K,V]) DeleteExpired() {
 	for i := 0; i < len(c.shards); i++ {
 		shard := c.shards[i]
-		shard.evictExpired(c.OnEvicted)
+		shard.evictExpired(c.evicted)
 	}
 }
 
-func (c *Cache[K,V]) IsExpired(key K) bool {
+// Checks if key is expired, ok is false if key doesn't exist at all.
+func (c *Cache[K,V]) IsExpired(key K) (ex bool, ok bool) {
 	shard := c.getShard(key)
 	return shard.isExpired(key)
 }
\ No newline at end of file
@@ -1,9 +1,19 @@
 package kv1
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unsafe"
+
+	"github.com/saintwish/kv"
+	"github.com/saintwish/kv/kvtest"
+	"github.com/saintwish/kv/clock"
+	"github.com/saintwish/kv/clock/clocktest"
 )
 
 func TestSetGet_KeyString(t *testing.T) {
@@ -24,6 +34,12 @@ func TestSetGet_KeyInt(t *testing.T) {
 	}
 }
 
+func TestConformance(t *testing.T) {
+	kvtest.Run(t, func() kv.Cache[string, int] {
+		return New[string, int](time.Minute, 2048, 32)
+	})
+}
+
 func TestFlush(t *testing.T) {
 	cache := New[int, string](time.Minute, 2048, 32)
 	cache.SetOnEvicted(func(k int, v string){
@@ -39,7 +55,8 @@ func TestFlush(t *testing.T) {
 }
 
 func TestExpired(t *testing.T) {
-	cache := New[int, string](200*time.Millisecond, 2048, 32)
+	clk := clocktest.NewFake(time.Now())
+	cache := NewWithClock[int, string](200*time.Millisecond, 2048, 32, clk)
 	cache.SetOnEvicted(func(k int, v string){
 		fmt.Printf("Evicted: %d\n", k)
 	})
@@ -49,7 +66,848 @@ func TestExpired(t *testing.T) {
 	cache.Set(3434, "leet haxiors2")
 	cache.Set(5465, "leet haxiors3")
 
-	time.Sleep(700*time.Millisecond)
+	clk.Advance(700*time.Millisecond)
+
+	cache.DeleteExpired()
+
+	if n := cache.Count(); n != 0 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", n, 0)
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	cache := NewWithJanitor[int, string](50*time.Millisecond, 2048, 32, 100*time.Millisecond)
+	defer cache.Close()
+
+	cache.Set(1337, "leet haxiors")
+	cache.Set(1338, "leet haxiors1")
+	cache.Set(3434, "leet haxiors2")
+	cache.Set(5465, "leet haxiors3")
+
+	time.Sleep(400*time.Millisecond)
+
+	if n := cache.Count(); n != 0 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", n, 0)
+	}
+}
+
+func TestJanitorClose(t *testing.T) {
+	cache := NewWithJanitor[int, string](time.Minute, 2048, 32, time.Millisecond)
+
+	cache.Close()
+	cache.Close()
+
+	cache.Set(1337, "leet haxiors")
+	if res := cache.Get(1337); res != "leet haxiors" {
+		t.Errorf("Result was incorrect, got: %s, want: %s.", res, "leet haxiors")
+	}
+}
+
+func TestSetWithTTL(t *testing.T) {
+	clk := clocktest.NewFake(time.Now())
+	cache := NewWithClock[int, string](time.Minute, 2048, 32, clk)
+
+	cache.SetWithTTL(1337, "leet haxiors", 50*time.Millisecond)
+	cache.SetWithTTL(1338, "leet haxiors1", NoExpiration)
+	cache.SetExpireAt(3434, "leet haxiors2", clk.Now().Add(50*time.Millisecond))
+	cache.SetExpireAt(5465, "leet haxiors3", time.Time{})
+	cache.Set(6000, "leet haxiors4")
+
+	clk.Advance(100*time.Millisecond)
+
+	want := map[int]bool{1337: true, 1338: false, 3434: true, 5465: false, 6000: false}
+	for k, ex := range want {
+		if res, _ := cache.IsExpired(k); res != ex {
+			t.Errorf("IsExpired(%d) was incorrect, got: %t, want: %t.", k, res, ex)
+		}
+	}
+
+	if err := cache.AddWithTTL(1338, "dupe", time.Minute); err == nil {
+		t.Errorf("AddWithTTL on existing key should error")
+	}
+
+	if err := cache.UpdateWithTTL(1337, "renewed", time.Minute); err == nil {
+		t.Errorf("UpdateWithTTL on expired key should error")
+	}
+
+	if err := cache.UpdateWithTTL(1338, "renewed", time.Millisecond); err != nil {
+		t.Errorf("UpdateWithTTL errored: %v", err)
+	}
+	clk.Advance(10*time.Millisecond)
+	if ex, _ := cache.IsExpired(1338); !ex {
+		t.Errorf("UpdateWithTTL did not change expiration")
+	}
+}
+
+func TestNegativeTTL(t *testing.T) {
+	clk := clocktest.NewFake(time.Now())
+	cache := NewWithClock[int, int](time.Hour, 64, 1, clk)
+
+	var expired []int
+	cache.SetOnEvictedReason(func(k int, v int, r kv.Reason) {
+		if r == kv.Expired {
+			expired = append(expired, k)
+		}
+	})
+
+	cache.SetWithTTL(1, 1, -5*time.Second)
+	cache.SetWithTTL(2, 2, NoExpiration)
+	if _, ok := cache.GetHas(1); ok {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", ok, false)
+	}
+	if _, ok := cache.GetHas(2); !ok {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", ok, true)
+	}
+
+	clk.Advance(2*time.Millisecond)
+	cache.DeleteExpired()
+	if fmt.Sprint(expired) != "[1]" || !cache.Has(2) {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", expired, []int{1})
+	}
+}
+
+func TestRenewKeepsTTL(t *testing.T) {
+	clk := clocktest.NewFake(time.Now())
+	cache := NewWithClock[int, string](50*time.Millisecond, 2048, 32, clk)
+
+	cache.SetWithTTL(1337, "leet haxiors", NoExpiration)
+	cache.Set(1338, "leet haxiors1")
+
+	clk.Advance(30*time.Millisecond)
+	cache.Renew(1338)
+	clk.Advance(30*time.Millisecond)
+
+	if !cache.Has(1337) || !cache.Has(1338) {
+		t.Errorf("Renewed items should not be expired")
+	}
+}
+
+func TestWheel(t *testing.T) {
+	start := time.Now().UnixNano()
+	w := newWheel[int](64, start)
+
+	//deadlines spread over every level of the wheel
+	deadlines := map[int]int64{}
+	for i, d := range []time.Duration{0, time.Millisecond, 50*time.Millisecond, time.Second, time.Minute, time.Hour, 48*time.Hour, 5*365*24*time.Hour} {
+		deadlines[i] = start + int64(d)
+		w.schedule(i, deadlines[i])
+	}
+
+	cancelled := w.schedule(100, start + int64(time.Second))
+	w.cancel(cancelled)
+
+	moved := w.schedule(101, start + int64(time.Hour))
+	w.reschedule(moved, start + int64(2*time.Millisecond))
+	deadlines[101] = start + int64(2*time.Millisecond)
+
+	expired := map[int]bool{}
+	for _, step := range []time.Duration{time.Millisecond, 10*time.Millisecond, 2*time.Second, 2*time.Hour, 24*time.Hour, 10*365*24*time.Hour} {
+		now := start + int64(step)
+		w.advance(now, func(key int) {
+			if deadlines[key] >= now {
+				t.Errorf("Key %d expired early at %v", key, step)
+			}
+			expired[key] = true
+		})
+
+		for key, deadline := range deadlines {
+			if deadline <= now - (1 << wheelShift) && !expired[key] {
+				t.Errorf("Key %d not expired at %v", key, step)
+			}
+		}
+	}
+
+	if expired[100] {
+		t.Errorf("Cancelled timer expired")
+	}
+	if len(expired) != len(deadlines) {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", len(expired), len(deadlines))
+	}
+}
+
+// Checks the wheel agrees with reads that an item is still live at exactly its deadline.
+func TestExpireBoundary(t *testing.T) {
+	clk := clocktest.NewFake(time.Now())
+	cache := NewWithClock[int, int](time.Hour, 64, 1, clk)
+
+	var evicted int
+	cache.SetOnEvictedReason(func(k int, v int, r kv.Reason) {
+		evicted++
+	})
+	cache.SetWithTTL(1, 1, time.Second)
+
+	clk.Advance(time.Second)
+	cache.DeleteExpired()
+	if _, ok := cache.GetHas(1); !ok || evicted != 0 {
+		t.Errorf("Result was incorrect, got: %v with %d evicted, want: %v with %d evicted.", ok, evicted, true, 0)
+	}
+
+	clk.Advance(time.Nanosecond)
+	if _, ok := cache.GetHas(1); ok {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", ok, false)
+	}
+
+	clk.Advance(2*time.Millisecond)
+	cache.DeleteExpired()
+	if evicted != 1 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", evicted, 1)
+	}
+}
+
+func TestDeleteExpiredOnlyExpired(t *testing.T) {
+	clk := clocktest.NewFake(time.Now())
+	cache := NewWithClock[int, int](time.Hour, 4096, 4, clk)
+
+	for i := 0; i < 1000; i++ {
+		if i%2 == 0 {
+			cache.SetWithTTL(i, i, 10*time.Millisecond)
+		} else {
+			cache.Set(i, i)
+		}
+	}
+	cache.Delete(0)
+	cache.SetWithTTL(2, 2, time.Hour)
+
+	clk.Advance(50*time.Millisecond)
+
+	var evicted int
+	cache.SetOnEvicted(func(k int, v int) {
+		if k%2 != 0 || k == 2 {
+			t.Errorf("Key %d should not have been evicted", k)
+		}
+		evicted++
+	})
+	cache.DeleteExpired()
+
+	if evicted != 498 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", evicted, 498)
+	}
+	if n := cache.Count(); n != 501 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", n, 501)
+	}
+}
+
+func TestExpiredInvisible(t *testing.T) {
+	clk := clocktest.NewFake(time.Now())
+	cache := NewWithClock[int, string](20*time.Millisecond, 2048, 32, clk)
+
+	cache.Set(1337, "leet haxiors")
+	clk.Advance(50*time.Millisecond)
+
+	if res := cache.Get(1337); res != "" {
+		t.Errorf("Result was incorrect, got: %s, want: %s.", res, "")
+	}
+	if _, ok := cache.GetHas(1337); ok {
+		t.Errorf("GetHas returned an expired item")
+	}
+	if _, ok := cache.GetHasRenew(1337); ok {
+		t.Errorf("GetHasRenew returned an expired item")
+	}
+	if cache.Has(1337) {
+		t.Errorf("Has returned true for an expired item")
+	}
+	if ex, ok := cache.IsExpired(1337); !ex || !ok {
+		t.Errorf("IsExpired was incorrect, got: %t %t, want: %t %t.", ex, ok, true, true)
+	}
+	if ex, ok := cache.IsExpired(1338); ex || ok {
+		t.Errorf("IsExpired was incorrect, got: %t %t, want: %t %t.", ex, ok, false, false)
+	}
+	if err := cache.Add(1337, "again"); err != nil {
+		t.Errorf("Add on expired key errored: %v", err)
+	}
+}
+
+func TestEvictOnAccess(t *testing.T) {
+	clk := clocktest.NewFake(time.Now())
+	cache := NewWithClock[int, string](20*time.Millisecond, 2048, 32, clk)
+	cache.EvictOnAccess = true
+
+	var evicted []int
+	cache.SetOnEvicted(func(k int, v string) {
+		evicted = append(evicted, k)
+	})
+
+	cache.Set(1337, "leet haxiors")
+	clk.Advance(50*time.Millisecond)
+
+	if _, ok := cache.GetHas(1337); ok {
+		t.Errorf("GetHas returned an expired item")
+	}
+	if len(evicted) != 1 || evicted[0] != 1337 {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", evicted, []int{1337})
+	}
+	if _, ok := cache.IsExpired(1337); ok {
+		t.Errorf("Expired item was not deleted on access")
+	}
+}
+
+func TestEvictedReason(t *testing.T) {
+	clk := clocktest.NewFake(time.Now())
+	cache := NewWithClock[int, string](time.Minute, 2048, 32, clk)
+
+	reasons := map[int]kv.Reason{}
+	cache.SetOnEvictedReason(func(k int, v string, r kv.Reason) {
+		reasons[k] = r
+	})
+	var legacy []int
+	cache.SetOnEvicted(func(k int, v string) {
+		legacy = append(legacy, k)
+	})
+
+	cache.Set(1, "one")
+	cache.Set(1, "uno")
+	cache.Set(2, "two")
+	cache.DeleteCallback(2)
+	cache.SetWithTTL(3, "three", time.Millisecond)
+	cache.Set(4, "four")
+
+	clk.Advance(10*time.Millisecond)
+	cache.DeleteExpired()
+	cache.Flush()
+
+	want := map[int]kv.Reason{1: kv.Flushed, 2: kv.Deleted, 3: kv.Expired, 4: kv.Flushed}
+	for k, r := range want {
+		if reasons[k] != r {
+			t.Errorf("Reason for %d was incorrect, got: %s, want: %s.", k, reasons[k], r)
+		}
+	}
+
+	if len(legacy) != 4 {
+		t.Errorf("OnEvicted should not be called for replaced items, got: %v.", legacy)
+	}
+}
+
+func TestGetOrLoad(t *testing.T) {
+	cache := New[int, string](time.Minute, 2048, 32)
+
+	var calls atomic.Int32
+	cache.SetLoader(func(ctx context.Context, k int) (string, error) {
+		calls.Add(1)
+		time.Sleep(20*time.Millisecond)
+		return fmt.Sprint(k), nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if res, err := cache.GetOrLoad(context.Background(), 1337); err != nil || res != "1337" {
+				t.Errorf("Result was incorrect, got: %s %v, want: %s.", res, err, "1337")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Loader was called %d times, want: %d.", n, 1)
+	}
+	if res := cache.Get(1337); res != "1337" {
+		t.Errorf("Loaded value was not cached, got: %s.", res)
+	}
+}
+
+func TestGetOrLoadError(t *testing.T) {
+	cache := New[int, string](time.Minute, 2048, 32)
+
+	if _, err := cache.GetOrLoad(context.Background(), 1); !errors.Is(err, kv.ErrNoLoader) {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", err, kv.ErrNoLoader)
+	}
+
+	fail := errors.New("backend down")
+	cache.SetLoader(func(ctx context.Context, k int) (string, error) {
+		return "", fail
+	})
+
+	if _, err := cache.GetOrLoad(context.Background(), 1); !errors.Is(err, fail) {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", err, fail)
+	}
+	if cache.Has(1) {
+		t.Errorf("Failed load was cached")
+	}
+
+	block := make(chan struct{})
+	cache.SetLoader(func(ctx context.Context, k int) (string, error) {
+		<-block
+		return "late", nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := cache.GetOrLoad(ctx, 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", err, context.DeadlineExceeded)
+	}
+
+	close(block)
+	if res, err := cache.GetOrLoad(context.Background(), 2); err != nil || res != "late" {
+		t.Errorf("Result was incorrect, got: %s %v, want: %s.", res, err, "late")
+	}
+}
+
+func TestRefreshAfter(t *testing.T) {
+	clk := clocktest.NewFake(time.Now())
+	cache := NewWithClock[int, string](time.Minute, 2048, 32, clk)
+	cache.SetRefreshAfter(20*time.Millisecond)
+
+	//the reload blocks until every read is done and tells the test once it has been cached.
+	release := make(chan struct{})
+	reloaded := make(chan struct{})
+	cache.SetOnEvictedReason(func(k int, v string, r kv.Reason) {
+		if r == kv.Replaced {
+			close(reloaded)
+		}
+	})
+
+	var calls atomic.Int32
+	cache.SetLoader(func(ctx context.Context, k int) (string, error) {
+		n := calls.Add(1)
+		if n > 1 {
+			<-release
+		}
+		return fmt.Sprint(n), nil
+	})
+
+	if res, _ := cache.GetOrLoad(context.Background(), 1337); res != "1" {
+		t.Errorf("Result was incorrect, got: %s, want: %s.", res, "1")
+	}
+
+	clk.Advance(30*time.Millisecond)
+
+	//past the refresh point every read gets the current value while a single reload runs.
+	for i := 0; i < 10; i++ {
+		if res, _ := cache.GetOrLoad(context.Background(), 1337); res != "1" {
+			t.Errorf("Result was incorrect, got: %s, want: %s.", res, "1")
+		}
+	}
+
+	close(release)
+	<-reloaded
+
+	if res := cache.Get(1337); res != "2" {
+		t.Errorf("Result was incorrect, got: %s, want: %s.", res, "2")
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("Loader was called %d times, want: %d.", n, 2)
+	}
+}
+
+func TestStaleIfError(t *testing.T) {
+	clk := clocktest.NewFake(time.Now())
+	cache := NewWithClock[int, string](20*time.Millisecond, 2048, 32, clk)
+	cache.SetStaleIfError(time.Minute)
+	cache.EvictOnAccess = true
+
+	fail := errors.New("backend down")
+	cache.SetLoader(func(ctx context.Context, k int) (string, error) {
+		return "", fail
+	})
+
+	cache.Set(1337, "leet haxiors")
+	clk.Advance(30*time.Millisecond)
 
+	if cache.Has(1337) {
+		t.Errorf("Has returned true for an expired item")
+	}
 	cache.DeleteExpired()
-}
\ No newline at end of file
+
+	if res, err := cache.GetOrLoad(context.Background(), 1337); err != nil || res != "leet haxiors" {
+		t.Errorf("Result was incorrect, got: %s %v, want: %s.", res, err, "leet haxiors")
+	}
+	if _, err := cache.GetOrLoad(context.Background(), 1338); !errors.Is(err, fail) {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", err, fail)
+	}
+}
+
+func BenchmarkSet_RealClock(b *testing.B) {
+	cache := New[int, int](time.Minute, 2048, 32)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Set(i&1023, i)
+	}
+}
+
+func BenchmarkSet_CoarseClock(b *testing.B) {
+	clk := clock.NewCoarse(time.Millisecond)
+	defer clk.Stop()
+	cache := NewWithClock[int, int](time.Minute, 2048, 32, clk)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Set(i&1023, i)
+	}
+}
+
+func TestItemSize(t *testing.T) {
+	//value, TTL, expiration and timer index, with no time.Time or pointer added for the GC.
+	if sz := unsafe.Sizeof(item[int64]{}); sz != 32 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", sz, 32)
+	}
+}
+
+func TestExpireAt(t *testing.T) {
+	clk := clocktest.NewFake(time.Now())
+	cache := NewWithClock[int, string](time.Minute, 2048, 32, clk)
+
+	cache.SetExpireAt(1, "past", clk.Now().Add(-time.Hour))
+	cache.SetExpireAt(2, "future", clk.Now().Add(time.Hour))
+
+	if cache.Has(1) {
+		t.Errorf("Item with a past deadline should be expired")
+	}
+
+	clk.Advance(time.Hour)
+	if !cache.Has(2) {
+		t.Errorf("Item should expire after its deadline, not at it")
+	}
+
+	clk.Advance(time.Nanosecond)
+	if cache.Has(2) {
+		t.Errorf("Item should be expired after its deadline")
+	}
+}
+
+func TestTTLInspection(t *testing.T) {
+	clk := clocktest.NewFake(time.Now())
+	cache := NewWithClock[int, string](time.Minute, 2048, 32, clk)
+
+	cache.Set(1, "one")
+	cache.SetWithTTL(2, "two", NoExpiration)
+
+	if val, deadline, ok := cache.GetWithExpiration(1); !ok || val != "one" || !deadline.Equal(clk.Now().Add(time.Minute)) {
+		t.Errorf("Result was incorrect, got: %s %v %t, want: %s %v %t.", val, deadline, ok, "one", clk.Now().Add(time.Minute), true)
+	}
+	if deadline, ok := cache.ExpiresAt(2); !ok || !deadline.IsZero() {
+		t.Errorf("Result was incorrect, got: %v %t, want: zero time %t.", deadline, ok, true)
+	}
+	if _, _, ok := cache.GetWithExpiration(3); ok {
+		t.Errorf("GetWithExpiration returned a missing item")
+	}
+
+	clk.Advance(20*time.Second)
+
+	if ttl, ok := cache.TTL(1); !ok || ttl != 40*time.Second {
+		t.Errorf("Result was incorrect, got: %v %t, want: %v %t.", ttl, ok, 40*time.Second, true)
+	}
+	if ttl, ok := cache.TTL(2); !ok || ttl != NoExpiration {
+		t.Errorf("Result was incorrect, got: %v %t, want: %v %t.", ttl, ok, NoExpiration, true)
+	}
+
+	//shorten one and give the other an expiration
+	if !cache.ChangeTTL(1, time.Second) || !cache.ChangeExpireAt(2, clk.Now().Add(time.Hour)) {
+		t.Errorf("Changing the expiration of existing items failed")
+	}
+	if cache.ChangeTTL(3, time.Second) {
+		t.Errorf("ChangeTTL succeeded on a missing item")
+	}
+	if ttl, _ := cache.TTL(2); ttl != time.Hour {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", ttl, time.Hour)
+	}
+
+	clk.Advance(2*time.Second)
+	cache.DeleteExpired()
+
+	if _, ok := cache.TTL(1); ok {
+		t.Errorf("Shortened item did not expire")
+	}
+	if n := cache.Count(); n != 1 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", n, 1)
+	}
+
+	//renewing uses the changed ttl
+	cache.Renew(2)
+	if ttl, _ := cache.TTL(2); ttl != time.Hour {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", ttl, time.Hour)
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	bad := []Config[int, int]{
+		{Capacity: 2048, Shards: 0},
+		{Capacity: 16, Shards: 32},
+		{Capacity: 2048, Shards: 32, Expiration: -time.Second},
+		{Capacity: 2048, Shards: 32, JanitorInterval: -time.Second},
+		{Capacity: 2048, Shards: 32, Expiration: time.Minute, RefreshAfter: time.Minute},
+	}
+
+	for _, cfg := range bad {
+		if _, err := NewFromConfig(cfg); !errors.Is(err, kv.ErrInvalidConfig) {
+			t.Errorf("Result was incorrect, got: %v, want: %v.", err, kv.ErrInvalidConfig)
+		}
+	}
+
+	cache, err := NewFromConfig(Config[int, int]{Capacity: 2048, Shards: 32, Expiration: time.Minute})
+	if err != nil {
+		t.Fatalf("Valid config was rejected: %v", err)
+	}
+	cache.Set(1, 1)
+	if res := cache.Get(1); res != 1 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", res, 1)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	clk := clocktest.NewFake(time.Unix(0, 0))
+	stats := &kv.Stats{}
+	cache, err := NewFromConfig(Config[int, int]{
+		Capacity: 2048,
+		Shards: 32,
+		Expiration: time.Minute,
+		Clock: clk,
+		Metrics: stats,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set(1, 1)
+	cache.Set(2, 2)
+	cache.Get(1)
+	cache.Get(3)
+	cache.Set(1, 10)
+	cache.DeleteCallback(2)
+
+	clk.Advance(2 * time.Minute)
+	cache.Get(1)
+	cache.DeleteExpired()
+
+	if stats.Hits() != 1 || stats.Misses() != 2 {
+		t.Errorf("Result was incorrect, got: %d hits %d misses, want: %d hits %d misses.", stats.Hits(), stats.Misses(), 1, 2)
+	}
+	if n := stats.Evictions(kv.Replaced); n != 1 {
+		t.Errorf("Result was incorrect, got: %d replaced, want: %d.", n, 1)
+	}
+	if n := stats.Evictions(kv.Deleted); n != 1 {
+		t.Errorf("Result was incorrect, got: %d deleted, want: %d.", n, 1)
+	}
+	if n := stats.Evictions(kv.Expired); n != 1 {
+		t.Errorf("Result was incorrect, got: %d expired, want: %d.", n, 1)
+	}
+}
+
+func TestCompute(t *testing.T) {
+	cache := New[string, int](time.Minute, 2048, 32)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				cache.Compute("counter", func(old int, exists bool) (int, kv.ComputeOp) {
+					return old + 1, kv.StoreOp
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if res := cache.Get("counter"); res != 8000 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", res, 8000)
+	}
+
+	if res, ok := cache.Compute("counter", func(old int, exists bool) (int, kv.ComputeOp) {
+		return 0, kv.CancelOp
+	}); !ok || res != 8000 {
+		t.Errorf("Result was incorrect, got: %d %t, want: %d %t.", res, ok, 8000, true)
+	}
+
+	if _, ok := cache.Compute("counter", func(old int, exists bool) (int, kv.ComputeOp) {
+		return 0, kv.DeleteOp
+	}); ok || cache.Has("counter") {
+		t.Errorf("Compute didn't delete the key")
+	}
+
+	if res, loaded := cache.ComputeIfAbsent("lazy", func() int { return 1 }); loaded || res != 1 {
+		t.Errorf("Result was incorrect, got: %d %t, want: %d %t.", res, loaded, 1, false)
+	}
+	if res, loaded := cache.ComputeIfAbsent("lazy", func() int { return 2 }); !loaded || res != 1 {
+		t.Errorf("Result was incorrect, got: %d %t, want: %d %t.", res, loaded, 1, true)
+	}
+}
+
+func TestSyncMapOps(t *testing.T) {
+	cache := New[string, int](time.Minute, 2048, 32)
+
+	if res, loaded := cache.LoadOrStore("a", 1); loaded || res != 1 {
+		t.Errorf("Result was incorrect, got: %d %t, want: %d %t.", res, loaded, 1, false)
+	}
+	if res, loaded := cache.LoadOrStore("a", 2); !loaded || res != 1 {
+		t.Errorf("Result was incorrect, got: %d %t, want: %d %t.", res, loaded, 1, true)
+	}
+
+	if cache.CompareAndSwap("a", 2, 3) {
+		t.Errorf("CompareAndSwap swapped a different value")
+	}
+	if !cache.CompareAndSwap("a", 1, 3) || cache.Get("a") != 3 {
+		t.Errorf("CompareAndSwap didn't swap, got: %d, want: %d.", cache.Get("a"), 3)
+	}
+	if cache.CompareAndSwap("missing", 0, 1) || cache.Has("missing") {
+		t.Errorf("CompareAndSwap set a missing key")
+	}
+
+	if cache.CompareAndDelete("a", 1) {
+		t.Errorf("CompareAndDelete deleted a different value")
+	}
+	if !cache.CompareAndDelete("a", 3) || cache.Has("a") {
+		t.Errorf("CompareAndDelete didn't delete")
+	}
+
+	cache.Set("b", 4)
+	if res, loaded := cache.LoadAndDelete("b"); !loaded || res != 4 || cache.Has("b") {
+		t.Errorf("Result was incorrect, got: %d %t, want: %d %t.", res, loaded, 4, true)
+	}
+	if _, loaded := cache.LoadAndDelete("b"); loaded {
+		t.Errorf("LoadAndDelete loaded a missing key")
+	}
+}
+
+func TestComputeExpired(t *testing.T) {
+	clk := clocktest.NewFake(time.Unix(0, 0))
+	cache := NewWithClock[string, int](time.Minute, 2048, 32, clk)
+
+	var reasons []kv.Reason
+	cache.SetOnEvictedReason(func(k string, v int, r kv.Reason) {
+		reasons = append(reasons, r)
+	})
+
+	cache.Set("a", 1)
+	clk.Advance(2 * time.Minute)
+
+	if res, loaded := cache.LoadOrStore("a", 2); loaded || res != 2 {
+		t.Errorf("Result was incorrect, got: %d %t, want: %d %t.", res, loaded, 2, false)
+	}
+	if len(reasons) != 1 || reasons[0] != kv.Expired {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", reasons, []kv.Reason{kv.Expired})
+	}
+}
+
+func TestBatch(t *testing.T) {
+	cache := New[int, int](time.Minute, 2048, 32)
+
+	items := make(map[int]int)
+	keys := make([]int, 0, 200)
+	for i := 0; i < 100; i++ {
+		items[i] = i * 2
+		keys = append(keys, i, i + 1000)
+	}
+	cache.SetMany(items)
+
+	vals := cache.GetMany(keys)
+	if len(vals) != 100 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", len(vals), 100)
+	}
+	for i := 0; i < 100; i++ {
+		if vals[i] != i * 2 {
+			t.Errorf("Result was incorrect, got: %d, want: %d.", vals[i], i * 2)
+		}
+	}
+
+	if n := cache.DeleteMany(keys[:100]); n != 50 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", n, 50)
+	}
+	if n := cache.Count(); n != 50 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", n, 50)
+	}
+}
+
+func TestForEachMode(t *testing.T) {
+	cache := New[int, int](time.Minute, 2048, 32)
+	for i := 0; i < 100; i++ {
+		cache.Set(i, i)
+	}
+
+	//the callback writing to the cache would deadlock if the shard was still locked.
+	cache.ForEachMode(kv.IterCopy, func(k int, v int) bool {
+		cache.Set(k, v + 1)
+		return true
+	})
+	for i := 0; i < 100; i++ {
+		if res := cache.Get(i); res != i + 1 {
+			t.Errorf("Result was incorrect, got: %d, want: %d.", res, i + 1)
+		}
+	}
+
+	for _, mode := range []kv.IterMode{kv.IterLocked, kv.IterCopy} {
+		var n int
+		cache.ForEachMode(mode, func(k int, v int) bool {
+			n++
+			return n < 10
+		})
+		if n != 10 {
+			t.Errorf("ForEachMode didn't stop, got: %d calls, want: %d.", n, 10)
+		}
+	}
+
+	var sum int
+	cache.ForEach(func(k int, v item[int]) {
+		sum += v.Object
+	})
+	if sum != 5050 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", sum, 5050)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	cache := New[int, int](time.Minute, 8192, 32)
+	for i := 0; i < 100; i++ {
+		cache.Set(i, i)
+	}
+
+	snap := cache.Snapshot()
+	cache.Set(0, 100)
+	cache.Delete(1)
+	cache.Set(200, 200)
+
+	if res := snap.Get(0); res != 0 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", res, 0)
+	}
+	if !snap.Has(1) || snap.Has(200) {
+		t.Errorf("Snapshot saw writes made after it was taken")
+	}
+	if res := cache.Get(0); res != 100 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", res, 100)
+	}
+
+	cache.Clear()
+	if n := snap.Count(); n != 100 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", n, 100)
+	}
+
+	var sum int
+	for _, v := range snap.All() {
+		sum += v
+	}
+	if sum != 4950 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", sum, 4950)
+	}
+}
+
+func TestSnapshotConsistent(t *testing.T) {
+	cache := New[int, int](time.Minute, 8192, 32)
+
+	//keys are set in order, so every snapshot must hold a prefix of them.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			cache.Set(i, i)
+		}
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+
+		snap := cache.Snapshot()
+		n := snap.Count()
+		for i := 0; i < n; i++ {
+			if !snap.Has(i) {
+				t.Fatalf("Snapshot of %d items is missing %d.", n, i)
+			}
+		}
+	}
+}
@@ -4,153 +4,384 @@ import (
 	"time"
 	"sync"
 
+	"github.com/saintwish/kv"
 	"github.com/saintwish/kv/swiss"
 )
 
+const (
+	// Passed as a ttl to use the cache's default expiration.
+	DefaultExpiration time.Duration = 0
+	// Passed as a ttl for items that never expire.
+	NoExpiration time.Duration = -1
+)
+
 type item[V any] struct {
 	Object V
-	Expire time.Time
+	TTL time.Duration //used when renewing the item
+	expire int64 //see timebase, never if the item doesn't expire
+	timer int32 //index of the item's timer in the shard's wheel, 0 if it never expires
+}
+
+func (i item[V]) expired(now int64) bool {
+	return now > i.expire
+}
+
+//Checks if the item was set at least after ago, items that never expire are never due.
+func (i item[V]) refreshDue(now int64, after time.Duration) bool {
+	return i.TTL > 0 && now >= i.expire - int64(i.TTL) + int64(after)
+}
+
+//Returns the item with its expiration pushed back by its TTL.
+func (i item[V]) renewed(now int64) item[V] {
+	if i.TTL > 0 {
+		i.expire = now + int64(i.TTL)
+	}
+
+	return i
 }
 
 //used internally
 type shard[K comparable, V any] struct {
 	Map *swiss.Map[K, item[V]]
 	Expiration time.Duration
+	wheel *wheel[K] //indexes when items expire
+	grace time.Duration //how long expired items are kept before being deleted
+	tb *timebase
+	shared bool //Map is held by a snapshot, so it's copied before being changed
 	sync.RWMutex //mutex
 }
 
-func newShard[K comparable, V any](ex time.Duration, size uint64, count uint64) *shard[K, V] {
+func newShard[K comparable, V any](ex time.Duration, size uint64, count uint64, tb *timebase) *shard[K, V] {
 	return &shard[K, V] {
 		Map: swiss.NewMap[K, item[V]]( uint32(size/count) ),
 		Expiration: ex,
+		wheel: newWheel[K](int(size/count), tb.now()),
+		tb: tb,
 	}
 }
 
-/*--------
-	Raw get functions.
-----------*/
-func (m *shard[K, V]) get(key K) (val V) {
-	m.RLock()
+//Copies Map if a snapshot holds it, called with the write lock before anything can change Map.
+func (m *shard[K, V]) own() {
+	if m.shared {
+		m.Map = m.Map.Clone()
+		m.shared = false
+	}
+}
 
-	val = m.Map.Get(key).Object
+//Marks Map as held by a snapshot and returns it, must hold the write lock.
+func (m *shard[K, V]) share() *swiss.Map[K, item[V]] {
+	m.shared = true
+	return m.Map
+}
 
-	m.RUnlock()
+//Sets key to itm keeping its timer in sync, timer being the one of the item replaced if any.
+//Must hold the write lock.
+func (m *shard[K, V]) put(key K, itm item[V], timer int32) {
+	switch {
+	case timer != 0 && itm.expire == never:
+		m.wheel.cancel(timer)
+		timer = 0
+	case timer != 0:
+		m.wheel.reschedule(timer, itm.expire + int64(m.grace))
+	case itm.expire != never:
+		timer = m.wheel.schedule(key, itm.expire + int64(m.grace))
+	}
+
+	itm.timer = timer
+	m.Map.Set(key, itm)
+}
+
+//Deletes key along with its timer, must hold the write lock.
+func (m *shard[K, V]) remove(key K) (ok bool, v item[V]) {
+	if ok, v = m.Map.Delete(key); ok && v.timer != 0 {
+		m.wheel.cancel(v.timer)
+	}
 
 	return
 }
 
-func (m *shard[K, V]) getRenew(key K) (val V) {
-	m.Lock()
+func (m *shard[K, V]) newItem(val V, ttl time.Duration) item[V] {
+	if ttl == DefaultExpiration {
+		ttl = m.Expiration
+	}
 
-	if ok,v := m.Map.GetHas(key); ok {
-		v.Expire = time.Now().Add(m.Expiration)
-		m.Map.Set(key, v)
-		val = v.Object
+	itm := item[V]{
+		Object: val,
+		TTL: ttl,
+		expire: never,
+	}
+	//other negative ttls give an item that's already expired, like a deadline in the past.
+	if ttl != 0 && ttl != NoExpiration {
+		itm.expire = m.tb.now() + int64(ttl)
 	}
 
-	m.Unlock()
+	return itm
+}
 
-	return
+func (m *shard[K, V]) newItemAt(val V, deadline time.Time) item[V] {
+	if deadline.IsZero() {
+		return m.newItem(val, NoExpiration)
+	}
+
+	expire := m.tb.fromTime(deadline)
+	return item[V]{
+		Object: val,
+		TTL: time.Duration(expire - m.tb.now()),
+		expire: expire,
+	}
 }
 
+/*--------
+	Raw get functions.
+	Expired items are treated as missing, ex tells the caller it found one.
+----------*/
 func (m *shard[K, V]) has(key K) (ok bool) {
 	m.RLock()
 
-	ok = m.Map.Has(key)
+	ok, v := m.Map.GetHas(key)
+	ok = ok && !v.expired(m.tb.now())
 
 	m.RUnlock()
 
 	return
 }
 
-func (m *shard[K, V]) getHas(key K) (val V, ok bool) {
+//Returns the item of key even if it's expired.
+func (m *shard[K, V]) getItem(key K) (v item[V], ok bool) {
 	m.RLock()
 
-	ok,v := m.Map.GetHas(key);
-	val = v.Object
+	ok, v = m.Map.GetHas(key)
 
 	m.RUnlock()
 
 	return
 }
 
-func (m *shard[K, V]) getHasRenew(key K) (val V, ok bool) {
-	m.Lock()
+func (m *shard[K, V]) getHas(key K) (val V, ok bool, ex bool) {
+	m.RLock()
 
-	if ok,v := m.Map.GetHas(key); ok {
-		v.Expire = time.Now().Add(m.Expiration)
-		m.Map.Set(key, v)
+	ok, v := m.Map.GetHas(key)
+	if ok && v.expired(m.tb.now()) {
+		ok, ex = false, true
+	} else {
 		val = v.Object
 	}
 
+	m.RUnlock()
+
+	return
+}
+
+func (m *shard[K, V]) getHasRenew(key K) (val V, ok bool, ex bool) {
+	m.Lock()
+	m.own()
+
+	var v item[V]
+	if ok,v = m.Map.GetHas(key); ok {
+		if v.expired(m.tb.now()) {
+			ok, ex = false, true
+		} else {
+			m.put(key, v.renewed(m.tb.now()), v.timer)
+			val = v.Object
+		}
+	}
+
 	m.Unlock()
 
 	return
 }
 
 
+//Adds the items of keys that aren't expired to vals.
+func (m *shard[K, V]) getMany(keys []K, vals map[K]V) {
+	m.RLock()
+
+	now := m.tb.now()
+	for _, key := range keys {
+		if ok, v := m.Map.GetHas(key); ok && !v.expired(now) {
+			vals[key] = v.Object
+		}
+	}
+
+	m.RUnlock()
+}
+
+
 /*--------
 	Other functions
 ----------*/
-func (m *shard[K, V]) set(key K, val V) {
-	itm := item[V]{
-		Object: val,
-		Expire: time.Now().Add(m.Expiration),
+func (m *shard[K, V]) set(key K, itm item[V], callback func(K, V, kv.Reason)) {
+	m.Lock()
+	m.own()
+
+	m.store(key, itm, callback)
+
+	m.Unlock()
+}
+
+//Sets key to itm telling callback about the item replaced if any, must hold the write lock.
+func (m *shard[K, V]) store(key K, itm item[V], callback func(K, V, kv.Reason)) {
+	ok, old := m.Map.GetHas(key)
+	m.put(key, itm, old.timer)
+
+	if ok {
+		if old.expired(m.tb.now()) {
+			callback(key, old.Object, kv.Expired)
+		} else {
+			callback(key, old.Object, kv.Replaced)
+		}
 	}
+}
 
+//Sets key only if it's missing or expired, returns false if it wasn't set.
+func (m *shard[K, V]) add(key K, itm item[V], callback func(K, V, kv.Reason)) (ok bool) {
 	m.Lock()
+	m.own()
 
-	m.Map.Set(key, itm)
+	found, old := m.Map.GetHas(key)
+	if !found || old.expired(m.tb.now()) {
+		ok = true
+		m.put(key, itm, old.timer)
+
+		if found {
+			callback(key, old.Object, kv.Expired)
+		}
+	}
 
 	m.Unlock()
+
+	return
 }
 
-func (m *shard[K, V]) update(key K, val V) {
-	itm := item[V]{
-		Object: val,
-		Expire: time.Now().Add(m.Expiration),
+//Sets key only if it exists and isn't expired, returns false if it wasn't set.
+func (m *shard[K, V]) update(key K, itm item[V], callback func(K, V, kv.Reason)) (ok bool) {
+	m.Lock()
+	m.own()
+
+	var old item[V]
+	if ok, old = m.Map.GetHas(key); ok && !old.expired(m.tb.now()) {
+		m.put(key, itm, old.timer)
+		callback(key, old.Object, kv.Replaced)
+	} else {
+		ok = false
 	}
 
+	m.Unlock()
+
+	return
+}
+
+//Calls f with the value of key, expired items being passed as missing, and applies its result.
+func (m *shard[K, V]) compute(key K, f func(V, bool) (V, kv.ComputeOp), callback func(K, V, kv.Reason)) (val V, ok bool) {
 	m.Lock()
+	m.own()
+	defer m.Unlock()
 
-	if ok := m.Map.Has(key); ok {
-		m.Map.Set(key, itm)
+	found, old := m.Map.GetHas(key)
+	exists := found && !old.expired(m.tb.now())
+	if exists {
+		val = old.Object
 	}
 
-	m.Unlock()
+	nv, op := f(val, exists)
+	switch op {
+	case kv.StoreOp:
+		m.put(key, m.newItem(nv, DefaultExpiration), old.timer)
+		val, ok = nv, true
+	case kv.DeleteOp:
+		if found {
+			m.remove(key)
+		}
+		var zero V
+		val = zero
+	default:
+		return val, exists
+	}
+
+	switch {
+	case exists && op == kv.StoreOp:
+		callback(key, old.Object, kv.Replaced)
+	case found && !exists:
+		callback(key, old.Object, kv.Expired)
+	}
+
+	return
 }
 
 func (m *shard[K, V]) delete(key K) (ok bool) {
 	m.Lock()
+	m.own()
 
-	ok, _ = m.Map.Delete(key)
+	ok, _ = m.remove(key)
 
 	m.Unlock()
 
 	return
 }
 
-func (m *shard[K, V]) isExpired(key K) (ex bool) {
+func (m *shard[K, V]) setMany(keys []K, items map[K]V, callback func(K, V, kv.Reason)) {
+	m.Lock()
+	m.own()
+
+	for _, key := range keys {
+		m.store(key, m.newItem(items[key], DefaultExpiration), callback)
+	}
+
+	m.Unlock()
+}
+
+func (m *shard[K, V]) deleteMany(keys []K) (count int) {
+	m.Lock()
+	m.own()
+
+	for _, key := range keys {
+		if ok, _ := m.remove(key); ok {
+			count++
+		}
+	}
+
+	m.Unlock()
+
+	return
+}
+
+func (m *shard[K, V]) deleteCallback(key K, callback func(K, V, kv.Reason)) (ok bool) {
+	m.Lock()
+	m.own()
+
+	ok, v := m.remove(key)
+	if ok {
+		callback(key, v.Object, kv.Deleted)
+	}
+
+	m.Unlock()
+
+	return
+}
+
+func (m *shard[K, V]) isExpired(key K) (ex bool, ok bool) {
 	m.RLock()
 
-	if ok,v:= m.Map.GetHas(key); ok {
-		ex = time.Now().After(v.Expire)
+	var v item[V]
+	if ok,v = m.Map.GetHas(key); ok {
+		ex = v.expired(m.tb.now())
 	}
 
 	m.RUnlock()
 	return
 }
 
-//Returns true if item is expired and thus evicted.
-func (m *shard[K, V]) evictItem(key K, callback func(K,V)) (ex bool) {
+//Returns true if item is expired and thus evicted, items still within the grace period are kept.
+func (m *shard[K, V]) evictItem(key K, callback func(K, V, kv.Reason)) (ex bool) {
 	m.Lock()
+	m.own()
 
 	ex = false
 	if ok,v := m.Map.GetHas(key); ok {
-		if time.Now().After(v.Expire) {
+		if v.expired(m.tb.now() - int64(m.grace)) {
 			ex = true
-			callback(key, v.Object)
-			m.Map.Delete(key)
+			m.remove(key)
+			callback(key, v.Object, kv.Expired)
 		}
 	}
 
@@ -159,55 +390,108 @@ func (m *shard[K, V]) evictItem(key K, callback func(K,V)) (ex bool) {
 	return
 }
 
-func (m *shard[K, V]) evictExpired(callback func(K,V)) {
+//Deletes the items whose timers are due, only touching those rather than the whole map.
+func (m *shard[K, V]) evictExpired(callback func(K, V, kv.Reason)) {
 	m.Lock()
+	m.own()
 
-	m.Map.Iter(func (key K, v item[V]) (stop bool) {
-		if time.Now().After(v.Expire) {
-			callback(key, v.Object)
-			m.Map.Delete(key)
-		}
-		
-		if stop {
-			m.Unlock()
-			return
-		}
-
-		return
+	m.wheel.advance(m.tb.now(), func(key K) {
+		_, v := m.Map.Delete(key)
+		callback(key, v.Object, kv.Expired)
 	})
 
 	m.Unlock()
 }
 
-func (m *shard[K, V]) renew(key K) {
-	expire := time.Now().Add(m.Expiration)
+//Replaces the expiration of key with the one of the item made by expire, returns false if key is missing or expired.
+func (m *shard[K, V]) setExpire(key K, expire func(val V) item[V]) (ok bool) {
+	m.Lock()
+	m.own()
+
+	var v item[V]
+	if ok, v = m.Map.GetHas(key); ok {
+		if v.expired(m.tb.now()) {
+			ok = false
+		} else {
+			m.put(key, expire(v.Object), v.timer)
+		}
+	}
+
+	m.Unlock()
+
+	return
+}
 
+func (m *shard[K, V]) renew(key K) {
 	m.Lock()
+	m.own()
 	
-	if ok,v := m.Map.GetHas(key); ok {
-		v.Expire = expire
+	if ok,v := m.Map.GetHas(key); ok && !v.expired(m.tb.now()) {
+		m.put(key, v.renewed(m.tb.now()), v.timer)
 	}
 
 	m.Unlock()
 }
 
+//Calls f for every item that isn't expired under the read lock, returns false if f stopped.
+func (m *shard[K, V]) iterate(f func(K, V) bool) (cont bool) {
+	m.RLock()
+
+	now := m.tb.now()
+	cont = true
+	m.Map.Iter(func(key K, v item[V]) (stop bool) {
+		if v.expired(now) {
+			return
+		}
+
+		cont = f(key, v.Object)
+		return !cont
+	})
+
+	m.RUnlock()
+
+	return
+}
+
+//Appends the items that aren't expired to keys and vals under the read lock.
+func (m *shard[K, V]) collect(keys []K, vals []item[V]) ([]K, []item[V]) {
+	m.RLock()
+
+	now := m.tb.now()
+	m.Map.Iter(func(key K, v item[V]) (stop bool) {
+		if !v.expired(now) {
+			keys = append(keys, key)
+			vals = append(vals, v)
+		}
+		return
+	})
+
+	m.RUnlock()
+
+	return keys, vals
+}
+
 func (m *shard[K, V]) clear() {
+	m.Lock()
+	m.own()
+
 	m.Map.Clear()
+	m.wheel.clear()
+
+	m.Unlock()
 }
 
-func (m *shard[K, V]) flush(callback func(K, V)) {
+func (m *shard[K, V]) flush(callback func(K, V, kv.Reason)) {
 	m.Lock()
+	m.own()
 
 	m.Map.Iter(func(key K, val item[V]) (stop bool) {
-		callback(key, val.Object)
+		callback(key, val.Object, kv.Flushed)
 		m.Map.Delete(key)
-		
-		if stop {
-			return
-		}
 
 		return
 	})
+	m.wheel.clear()
 
 	m.Unlock()
 }
\ No newline at end of file
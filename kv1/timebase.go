@@ -0,0 +1,39 @@
+package kv1
+
+import (
+	"math"
+	"time"
+
+	"github.com/saintwish/kv/clock"
+)
+
+// Stored as the expiration of items that never expire, no time can be after it.
+const never int64 = math.MaxInt64
+
+// Converts between time.Time and the expirations stored in items, which are kept as
+// nanoseconds since the cache was created. That's 8 bytes without a pointer for the GC
+// to scan instead of the 24 of a time.Time, and the monotonic reading of the clock is
+// still used as long as the clock's times have one.
+type timebase struct {
+	clock clock.Clock
+	epoch time.Time
+}
+
+func newTimebase(clk clock.Clock) *timebase {
+	return &timebase{
+		clock: clk,
+		epoch: clk.Now(),
+	}
+}
+
+func (tb *timebase) now() int64 {
+	return tb.fromTime(tb.clock.Now())
+}
+
+func (tb *timebase) fromTime(t time.Time) int64 {
+	return int64(t.Sub(tb.epoch))
+}
+
+func (tb *timebase) toTime(n int64) time.Time {
+	return tb.epoch.Add(time.Duration(n))
+}
@@ -0,0 +1,136 @@
+package kv1
+
+import (
+	"github.com/saintwish/kv/internal/list"
+)
+
+// A hierarchical timing wheel indexing when items expire, so sweeping a shard
+// only touches the items that are actually expiring instead of the whole map.
+//
+// Each level has wheelSlots slots, a slot of level L spans 2^(wheelShift+L*wheelBits)
+// nanoseconds, which is about 1ms for the first level and a bit over 2 years for the
+// whole wheel. Timers go in the lowest level their deadline fits in and cascade down
+// as time advances, items expiring later than the wheel reaches wait in its last slot.
+const (
+	wheelBits = 6
+	wheelSlots = 1 << wheelBits
+	wheelMask = wheelSlots - 1
+	wheelLevels = 6
+	wheelShift = 20
+)
+
+type timer[K comparable] struct {
+	key K
+	expire int64 //same unit as item.expire, see timebase
+	slot int32 //index into wheel.slots
+}
+
+//used internally
+type wheel[K comparable] struct {
+	timers *list.Arena[timer[K]]
+	slots [wheelLevels*wheelSlots]list.List
+	now int64 //time of the last advance
+}
+
+func newWheel[K comparable](size int, now int64) *wheel[K] {
+	return &wheel[K]{
+		timers: list.NewArena[timer[K]](size),
+		now: now,
+	}
+}
+
+//Returns the slot a timer expiring at expire belongs in.
+func (w *wheel[K]) slotFor(expire int64) int32 {
+	for level := 0; level < wheelLevels; level++ {
+		shift := wheelShift + level*wheelBits
+		cur := w.now >> shift
+		diff := (expire >> shift) - cur
+
+		if diff < wheelSlots {
+			if diff <= 0 {
+				//due within the current tick, the next advance picks it up.
+				diff = 1
+			}
+			return int32(level*wheelSlots) + int32((cur+diff)&wheelMask)
+		}
+	}
+
+	//too far ahead, park it in the furthest slot and let it cascade from there.
+	shift := wheelShift + (wheelLevels-1)*wheelBits
+	return int32((wheelLevels-1)*wheelSlots) + int32(((w.now>>shift)+wheelMask)&wheelMask)
+}
+
+//Adds a timer for key and returns its index.
+func (w *wheel[K]) schedule(key K, expire int64) int32 {
+	slot := w.slotFor(expire)
+	return w.timers.PushBack(&w.slots[slot], timer[K]{key: key, expire: expire, slot: slot})
+}
+
+//Moves timer i to a new deadline.
+func (w *wheel[K]) reschedule(i int32, expire int64) {
+	t := w.timers.Value(i)
+	w.timers.Unlink(&w.slots[t.slot], i)
+
+	t.expire = expire
+	t.slot = w.slotFor(expire)
+	w.timers.LinkBack(&w.slots[t.slot], i)
+}
+
+//Removes timer i.
+func (w *wheel[K]) cancel(i int32) {
+	t := w.timers.Value(i)
+	w.timers.Remove(&w.slots[t.slot], i)
+}
+
+// Moves the wheel to now, calling expire for every timer that is due. Only the
+// slots passed since the last advance get visited and due timers are removed
+// before expire is called.
+func (w *wheel[K]) advance(now int64, expire func(key K)) {
+	if now <= w.now {
+		return
+	}
+
+	old := w.now
+	w.now = now
+
+	var due list.List
+	for level := 0; level < wheelLevels; level++ {
+		shift := wheelShift + level*wheelBits
+		prev, cur := old>>shift, now>>shift
+		if prev == cur {
+			break //higher levels haven't moved either
+		}
+
+		n := cur - prev
+		if n > wheelSlots {
+			n = wheelSlots
+		}
+
+		for tick := prev+1; tick <= prev+n; tick++ {
+			w.timers.Splice(&due, &w.slots[level*wheelSlots + int(tick&wheelMask)])
+		}
+	}
+
+	for i := w.timers.Front(&due); i != 0; {
+		next := w.timers.Next(i)
+		t := w.timers.Value(i)
+
+		if t.expire < now {
+			key := t.key
+			w.timers.Remove(&due, i)
+			expire(key)
+		} else {
+			w.timers.Unlink(&due, i)
+			t.slot = w.slotFor(t.expire)
+			w.timers.LinkBack(&w.slots[t.slot], i)
+		}
+
+		i = next
+	}
+}
+
+//Removes every timer.
+func (w *wheel[K]) clear() {
+	w.timers.Clear()
+	w.slots = [wheelLevels*wheelSlots]list.List{}
+}
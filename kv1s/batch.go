@@ -0,0 +1,56 @@
+package kv1s
+
+// Groups keys by the index of their shard, hashing each key once.
+func (c *Cache[K, V]) groupKeys(keys []K) [][]K {
+	groups := make([][]K, c.shardCount)
+	for _, key := range keys {
+		i := c.getShardIndex(key)
+		groups[i] = append(groups[i], key)
+	}
+
+	return groups
+}
+
+// Gets the values of keys, locking each shard once. Missing keys are left out of the map.
+func (c *Cache[K, V]) GetMany(keys []K) map[K]V {
+	vals := make(map[K]V, len(keys))
+	for i, group := range c.groupKeys(keys) {
+		if len(group) > 0 {
+			c.shards[i].getMany(group, vals)
+		}
+	}
+
+	if c.metrics != nil {
+		for i := 0; i < len(keys); i++ {
+			_, ok := vals[keys[i]]
+			c.record(ok)
+		}
+	}
+
+	return vals
+}
+
+// Sets every key of items to its value, locking each shard once.
+func (c *Cache[K, V]) SetMany(items map[K]V) {
+	keys := make([]K, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+
+	for i, group := range c.groupKeys(keys) {
+		if len(group) > 0 {
+			c.shards[i].setMany(group, items, c.deleted)
+		}
+	}
+}
+
+// Deletes keys, locking each shard once, and returns how many existed.
+func (c *Cache[K, V]) DeleteMany(keys []K) (count int) {
+	for i, group := range c.groupKeys(keys) {
+		if len(group) > 0 {
+			count += c.shards[i].deleteMany(group)
+		}
+	}
+
+	return
+}
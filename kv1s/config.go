@@ -0,0 +1,61 @@
+package kv1s
+
+import (
+	"fmt"
+
+	"github.com/dolthub/maphash"
+	"github.com/saintwish/kv"
+)
+
+// Settings of a cache made by NewFromConfig, only Capacity and Shards are required.
+type Config[K comparable, V any] struct {
+	Capacity uint64 //size of the cache, split evenly between the shards
+	Shards uint64 //number of shards, can't be more than Capacity
+
+	OnDeleted func(K, V)
+	OnDeletedReason func(K, V, kv.Reason)
+	Loader kv.Loader[K, V]
+
+	Hasher func(K) uint64 //picks the shard of keys, a randomly seeded maphash by default
+	Metrics kv.Metrics //told about hits, misses and deletions if set
+}
+
+func (cfg *Config[K, V]) validate() error {
+	switch {
+	case cfg.Shards == 0:
+		return fmt.Errorf("kv1s: shard count must be greater than zero: %w", kv.ErrInvalidConfig)
+	case cfg.Shards > cfg.Capacity:
+		return fmt.Errorf("kv1s: shard count must be smaller than cache size: %w", kv.ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// Creates a cache from cfg, returning an error wrapping kv.ErrInvalidConfig instead of
+// panicking if it's invalid.
+func NewFromConfig[K comparable, V any](cfg Config[K, V]) (*Cache[K, V], error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	if cfg.Hasher == nil {
+		cfg.Hasher = maphash.NewHasher[K]().Hash
+	}
+
+	cache := &Cache[K, V] {
+		shards: make([]*shard[K, V], cfg.Shards),
+		shardCount: cfg.Shards,
+		hash: cfg.Hasher,
+		metrics: cfg.Metrics,
+
+		OnDeleted: cfg.OnDeleted,
+		OnDeletedReason: cfg.OnDeletedReason,
+		Loader: cfg.Loader,
+	}
+
+	for i := 0; i < int(cfg.Shards); i++ {
+		cache.shards[i] = newShard[K, V](cfg.Capacity, cfg.Shards)
+	}
+
+	return cache, nil
+}
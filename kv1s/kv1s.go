@@ -1,59 +1,104 @@
 package kv1s
 
 import (
+	"context"
 	"fmt"
+	"iter"
 	
-	"github.com/dolthub/maphash"
+	"github.com/saintwish/kv"
+	"github.com/saintwish/kv/internal/flight"
 )
 
 type Cache[K comparable, V any] struct {
 	shards []*shard[K, V]
 	shardCount uint64
-	hash maphash.Hasher[K]
+	hash func(K) uint64
+	metrics kv.Metrics
 
 	OnDeleted func(K, V) //function that's called when cached item is deleted by the system
-}
+	OnDeletedReason func(K, V, kv.Reason) //same as OnDeleted but also told why, called for replaced items too
+	Loader kv.Loader[K, V] //function that's called by GetOrLoad to load missing items
 
-func New[K comparable, V any](sz uint64, sc uint64) *Cache[K, V] {
-	if sc > sz {
-		panic("kv1s: shard count must be smaller than cache size!")
-	}
+	flight flight.Group[K, V]
+}
 
-	cache := Cache[K, V] {}
-	cache.shards = make([]*shard[K, V], sc)
-	cache.hash = maphash.NewHasher[K]()
-	cache.shardCount = sc
+var _ kv.Cache[string, any] = (*Cache[string, any])(nil)
 
-	for i := 0; i < int(sc); i++ {
-		cache.shards[i] = newShard[K, V](sz, sc)
+// Panics if the shard count is bigger than the size, use NewFromConfig to get an error instead.
+func New[K comparable, V any](sz uint64, sc uint64) *Cache[K, V] {
+	cache, err := NewFromConfig(Config[K, V]{
+		Capacity: sz,
+		Shards: sc,
+	})
+	if err != nil {
+		panic(err)
 	}
 
-	return &cache
+	return cache
 }
 
 func (c *Cache[K, V]) getShardIndex(key K) uint64 {
-	sum := c.hash.Hash(key)
+	sum := c.hash(key)
 
 	return sum % c.shardCount
 }
 
 func (c *Cache[K, V]) getShard(key K) *shard[K, V] {
-	sum := c.hash.Hash(key)
+	sum := c.hash(key)
 	return c.shards[sum%c.shardCount]
 }
 
+// Tells the metrics if a read was a hit.
+func (c *Cache[K, V]) record(hit bool) {
+	if c.metrics == nil {
+		return
+	}
+
+	if hit {
+		c.metrics.Hit()
+	} else {
+		c.metrics.Miss()
+	}
+}
+
 func (c *Cache[K, V]) SetOnDeleted(f func(K, V)) {
 	c.OnDeleted = f
 }
 
+func (c *Cache[K, V]) SetOnDeletedReason(f func(K, V, kv.Reason)) {
+	c.OnDeletedReason = f
+}
+
+func (c *Cache[K, V]) SetLoader(f kv.Loader[K, V]) {
+	c.Loader = f
+}
+
+// Calls the deletion callbacks, OnDeleted keeps its old behaviour of not being called for replaced items.
+func (c *Cache[K, V]) deleted(key K, val V, reason kv.Reason) {
+	if c.metrics != nil {
+		c.metrics.Evict(reason)
+	}
+
+	if c.OnDeletedReason != nil {
+		c.OnDeletedReason(key, val, reason)
+	}
+
+	if c.OnDeleted != nil && reason != kv.Replaced {
+		c.OnDeleted(key, val)
+	}
+}
+
 func (c *Cache[K, V]) Get(key K) V {
-	shard := c.getShard(key)
-	return shard.get(key)
+	val, _ := c.GetHas(key)
+	return val
 }
 
 func (c *Cache[K, V]) GetHas(key K) (V, bool) {
 	shard := c.getShard(key)
-	return shard.getHas(key)
+	val, ok := shard.getHas(key)
+	c.record(ok)
+
+	return val, ok
 }
 
 func (c *Cache[K, V]) Has(key K) bool {
@@ -61,42 +106,131 @@ func (c *Cache[K, V]) Has(key K) bool {
 	return shard.has(key)
 }
 
+// Gets the value of key, calling Loader to load and cache it if it's missing. Concurrent
+// misses of the same key share a single call of Loader and its errors are returned without
+// caching anything. If ctx is done first its error is returned, the load carries on for the
+// other callers and still gets cached.
+func (c *Cache[K, V]) GetOrLoad(ctx context.Context, key K) (V, error) {
+	if val, ok := c.GetHas(key); ok {
+		return val, nil
+	}
+
+	if c.Loader == nil {
+		var val V
+		return val, kv.ErrNoLoader
+	}
+
+	return c.flight.Do(ctx, key, func(ctx context.Context) (V, error) {
+		//another load may have finished between the miss and this one starting.
+		if val, ok := c.getShard(key).getHas(key); ok {
+			return val, nil
+		}
+
+		val, err := c.Loader(ctx, key)
+		if err == nil {
+			c.Set(key, val)
+		}
+
+		return val, err
+	})
+}
+
 // Sets the key with value, will overwrite if key exists
 func (c *Cache[K, V]) Set(key K, val V) {
 	shard := c.getShard(key)
-	shard.set(key, val)
+	shard.set(key, val, c.deleted)
 }
 
 // Adds key with value to map, will error if key already exists.
 func (c *Cache[K, V]) Add(key K, val V) error {
 	shard := c.getShard(key)
-	if shard.has(key) {
+	if !shard.add(key, val) {
 		return fmt.Errorf("kv1s: Data already exists with given key %T", key)
 	}
 
-	shard.set(key, val)
 	return nil
 }
 
 // Updates given key, errors if key doesn't already exists.
 func (c *Cache[K, V]) Update(key K, val V) error {
 	shard := c.getShard(key)
-	if !shard.has(key) {
+	if !shard.update(key, val, c.deleted) {
 		return fmt.Errorf("kv1s: Data doesn't exists with given key %T", key)
 	}
 
-	shard.update(key, val)
 	return nil
 }
 
-// Will Set or Update said key depending if exists or not.
+// Will Set or Update said key depending if exists or not, in a single step.
 func (c *Cache[K, V]) SetOrUpdate(key K, val V) {
 	shard := c.getShard(key)
-	if shard.has(key) {
-		shard.update(key, val)
-	}else{
-		shard.set(key, val)
-	}
+	shard.set(key, val, c.deleted)
+}
+
+// Calls f with the current value of key, or the zero value and false if it's missing, and
+// applies the returned op. The shard of key stays locked while f runs, so f must be quick
+// and must not use the cache. Returns the value of key afterwards and if it exists.
+func (c *Cache[K, V]) Compute(key K, f func(old V, exists bool) (V, kv.ComputeOp)) (V, bool) {
+	shard := c.getShard(key)
+	return shard.compute(key, f, c.deleted)
+}
+
+// Sets key to the value returned by f if it's missing, f being called under the shard lock.
+// Returns the value of key and true if it already existed.
+func (c *Cache[K, V]) ComputeIfAbsent(key K, f func() V) (actual V, loaded bool) {
+	actual, _ = c.Compute(key, func(old V, exists bool) (V, kv.ComputeOp) {
+		if loaded = exists; exists {
+			return old, kv.CancelOp
+		}
+
+		return f(), kv.StoreOp
+	})
+
+	return
+}
+
+// Gets the value of key if it exists, otherwise sets it to val. Loaded is true if the value
+// was already there.
+func (c *Cache[K, V]) LoadOrStore(key K, val V) (actual V, loaded bool) {
+	return c.ComputeIfAbsent(key, func() V {
+		return val
+	})
+}
+
+// Deletes key, returning its value and if it existed.
+func (c *Cache[K, V]) LoadAndDelete(key K) (val V, loaded bool) {
+	c.Compute(key, func(old V, exists bool) (V, kv.ComputeOp) {
+		val, loaded = old, exists
+		return old, kv.DeleteOp
+	})
+
+	return
+}
+
+// Sets key to new if its value is equal to old, panics like sync.Map if V isn't comparable.
+func (c *Cache[K, V]) CompareAndSwap(key K, old V, new V) (swapped bool) {
+	c.Compute(key, func(cur V, exists bool) (V, kv.ComputeOp) {
+		if swapped = exists && any(cur) == any(old); swapped {
+			return new, kv.StoreOp
+		}
+
+		return cur, kv.CancelOp
+	})
+
+	return
+}
+
+// Deletes key if its value is equal to old, panics like sync.Map if V isn't comparable.
+func (c *Cache[K, V]) CompareAndDelete(key K, old V) (deleted bool) {
+	c.Compute(key, func(cur V, exists bool) (V, kv.ComputeOp) {
+		if deleted = exists && any(cur) == any(old); deleted {
+			return cur, kv.DeleteOp
+		}
+
+		return cur, kv.CancelOp
+	})
+
+	return
 }
 
 // Deletes key and returns boolean if sucessful.
@@ -108,7 +242,7 @@ func (c *Cache[K, V]) Delete(key K) bool {
 // Deletes key and returns boolean if sucessful OnDeleted callback.
 func (c *Cache[K, V]) DeleteCallback(key K) bool {
 	shard := c.getShard(key)
-	return shard.deleteCallback(key, c.OnDeleted)
+	return shard.deleteCallback(key, c.deleted)
 }
 
 func (c *Cache[K, V]) ShardCount() uint64 {
@@ -134,7 +268,9 @@ func (c *Cache[K, V]) GetShardCapacity(key K) int {
 func (c *Cache[K, V]) Count() (count int) {
 	for i := 0; i < len(c.shards); i++ {
 		shard := c.shards[i]
+		shard.RLock()
 		count = count + shard.Map.Count()
+		shard.RUnlock()
 	}
 	return
 }
@@ -143,7 +279,7 @@ func (c *Cache[K, V]) Count() (count int) {
 func (c *Cache[K, V]) Flush() {
 	for i := 0; i < len(c.shards); i++ {
 		shard := c.shards[i]
-		shard.flush(c.OnDeleted)
+		shard.flush(c.deleted)
 	}
 }
 
@@ -154,20 +290,63 @@ func (c *Cache[K, V]) Clear() {
 	}
 }
 
-func (c *Cache[K, V]) ForEach(f func(key K, val V)) {
+// Calls f for every item until it returns false. Shards are read locked one at a time
+// while f is called for their items, so f must not write to the cache.
+func (c *Cache[K, V]) Range(f func(key K, val V) bool) {
 	for i := 0; i < len(c.shards); i++ {
-		shard := c.shards[i]
-		shard.Lock()
-		defer shard.Unlock()
+		if !c.shards[i].iterate(f) {
+			return
+		}
+	}
+}
 
-		shard.Map.Iter(func(key K, val V) (stop bool) {
-			f(key, val)
+// Returns an iterator over the items for use with range, it works like Range so breaking
+// out of the loop stops it and the loop body must not write to the cache.
+func (c *Cache[K, V]) All() iter.Seq2[K, V] {
+	return c.Range
+}
 
-			if stop {
-				return
-			}
+// Returns an iterator over the keys, see All.
+func (c *Cache[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		c.Range(func(key K, _ V) bool {
+			return yield(key)
+		})
+	}
+}
 
-			return
+// Returns an iterator over the values, see All.
+func (c *Cache[K, V]) Values() iter.Seq[V] {
+	return func(yield func(V) bool) {
+		c.Range(func(_ K, val V) bool {
+			return yield(val)
 		})
 	}
-}
\ No newline at end of file
+}
+
+// Calls f for every item until it returns false, see kv.IterMode for how shards are locked.
+func (c *Cache[K, V]) ForEachMode(mode kv.IterMode, f func(key K, val V) bool) {
+	if mode == kv.IterLocked {
+		c.Range(f)
+		return
+	}
+
+	var keys []K
+	var vals []V
+	for i := 0; i < len(c.shards); i++ {
+		keys, vals = c.shards[i].collect(keys[:0], vals[:0])
+		for j := range keys {
+			if !f(keys[j], vals[j]) {
+				return
+			}
+		}
+	}
+}
+
+// Calls f for every item, copying one shard at a time so f may use the cache.
+func (c *Cache[K, V]) ForEach(f func(key K, val V)) {
+	c.ForEachMode(kv.IterCopy, func(key K, val V) bool {
+		f(key, val)
+		return true
+	})
+}
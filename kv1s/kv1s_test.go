@@ -1,8 +1,16 @@
 package kv1s
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/saintwish/kv"
+	"github.com/saintwish/kv/kvtest"
 )
 
 func TestSetGet_KeyString(t *testing.T) {
@@ -23,6 +31,12 @@ func TestSetGet_KeyInt(t *testing.T) {
 	}
 }
 
+func TestConformance(t *testing.T) {
+	kvtest.Run(t, func() kv.Cache[string, int] {
+		return New[string, int](2048, 32)
+	})
+}
+
 func TestFlush(t *testing.T) {
 	cache := New[int, string](2048, 32)
 	cache.SetOnDeleted(func(k int, v string){
@@ -35,4 +49,354 @@ func TestFlush(t *testing.T) {
 	cache.Set(5465, "leet haxiors3")
 
 	cache.Flush()
-}
\ No newline at end of file
+}
+
+func TestEvictedReason(t *testing.T) {
+	cache := New[int, string](2048, 32)
+	reasons := map[int][]kv.Reason{}
+	cache.SetOnDeletedReason(func(k int, v string, r kv.Reason) {
+		reasons[k] = append(reasons[k], r)
+	})
+
+	cache.Set(1, "one")
+	cache.Set(1, "uno")
+	cache.Set(2, "two")
+	cache.DeleteCallback(2)
+	cache.DeleteCallback(3)
+	cache.Flush()
+
+	if res := reasons[1]; len(res) != 2 || res[0] != kv.Replaced || res[1] != kv.Flushed {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", res, []kv.Reason{kv.Replaced, kv.Flushed})
+	}
+	if res := reasons[2]; len(res) != 1 || res[0] != kv.Deleted {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", res, []kv.Reason{kv.Deleted})
+	}
+	if res := reasons[3]; len(res) != 0 {
+		t.Errorf("Callback called for missing key, got: %v.", res)
+	}
+}
+
+func TestGetOrLoad(t *testing.T) {
+	cache := New[int, string](2048, 32)
+
+	var calls atomic.Int32
+	cache.SetLoader(func(ctx context.Context, k int) (string, error) {
+		calls.Add(1)
+		time.Sleep(20*time.Millisecond)
+		return fmt.Sprint(k), nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if res, err := cache.GetOrLoad(context.Background(), 1337); err != nil || res != "1337" {
+				t.Errorf("Result was incorrect, got: %s %v, want: %s.", res, err, "1337")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Loader was called %d times, want: %d.", n, 1)
+	}
+	if res := cache.Get(1337); res != "1337" {
+		t.Errorf("Loaded value was not cached, got: %s.", res)
+	}
+}
+
+func TestGetOrLoadError(t *testing.T) {
+	cache := New[int, string](2048, 32)
+
+	if _, err := cache.GetOrLoad(context.Background(), 1); !errors.Is(err, kv.ErrNoLoader) {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", err, kv.ErrNoLoader)
+	}
+
+	fail := errors.New("backend down")
+	cache.SetLoader(func(ctx context.Context, k int) (string, error) {
+		return "", fail
+	})
+
+	if _, err := cache.GetOrLoad(context.Background(), 1); !errors.Is(err, fail) {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", err, fail)
+	}
+	if cache.Has(1) {
+		t.Errorf("Failed load was cached")
+	}
+
+	block := make(chan struct{})
+	cache.SetLoader(func(ctx context.Context, k int) (string, error) {
+		<-block
+		return "late", nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := cache.GetOrLoad(ctx, 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", err, context.DeadlineExceeded)
+	}
+
+	close(block)
+	if res, err := cache.GetOrLoad(context.Background(), 2); err != nil || res != "late" {
+		t.Errorf("Result was incorrect, got: %s %v, want: %s.", res, err, "late")
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	bad := []Config[int, int]{
+		{Capacity: 2048, Shards: 0},
+		{Capacity: 16, Shards: 32},
+	}
+
+	for _, cfg := range bad {
+		if _, err := NewFromConfig(cfg); !errors.Is(err, kv.ErrInvalidConfig) {
+			t.Errorf("Result was incorrect, got: %v, want: %v.", err, kv.ErrInvalidConfig)
+		}
+	}
+
+	cache, err := NewFromConfig(Config[int, int]{Capacity: 2048, Shards: 32})
+	if err != nil {
+		t.Fatalf("Valid config was rejected: %v", err)
+	}
+	cache.Set(1, 1)
+	if res := cache.Get(1); res != 1 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", res, 1)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	stats := &kv.Stats{}
+	cache, err := NewFromConfig(Config[int, int]{
+		Capacity: 2048,
+		Shards: 32,
+		Metrics: stats,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set(1, 1)
+	cache.Set(2, 2)
+	cache.Get(1)
+	cache.Get(3)
+	cache.Set(1, 10)
+	cache.DeleteCallback(2)
+
+	if stats.Hits() != 1 || stats.Misses() != 1 {
+		t.Errorf("Result was incorrect, got: %d hits %d misses, want: %d hits %d misses.", stats.Hits(), stats.Misses(), 1, 1)
+	}
+	if n := stats.Evictions(kv.Replaced); n != 1 {
+		t.Errorf("Result was incorrect, got: %d replaced, want: %d.", n, 1)
+	}
+	if n := stats.Evictions(kv.Deleted); n != 1 {
+		t.Errorf("Result was incorrect, got: %d deleted, want: %d.", n, 1)
+	}
+}
+
+func TestCompute(t *testing.T) {
+	cache := New[string, int](2048, 32)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				cache.Compute("counter", func(old int, exists bool) (int, kv.ComputeOp) {
+					return old + 1, kv.StoreOp
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if res := cache.Get("counter"); res != 8000 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", res, 8000)
+	}
+
+	if res, ok := cache.Compute("counter", func(old int, exists bool) (int, kv.ComputeOp) {
+		return 0, kv.CancelOp
+	}); !ok || res != 8000 {
+		t.Errorf("Result was incorrect, got: %d %t, want: %d %t.", res, ok, 8000, true)
+	}
+
+	if _, ok := cache.Compute("counter", func(old int, exists bool) (int, kv.ComputeOp) {
+		return 0, kv.DeleteOp
+	}); ok || cache.Has("counter") {
+		t.Errorf("Compute didn't delete the key")
+	}
+
+	if res, loaded := cache.ComputeIfAbsent("lazy", func() int { return 1 }); loaded || res != 1 {
+		t.Errorf("Result was incorrect, got: %d %t, want: %d %t.", res, loaded, 1, false)
+	}
+	if res, loaded := cache.ComputeIfAbsent("lazy", func() int { return 2 }); !loaded || res != 1 {
+		t.Errorf("Result was incorrect, got: %d %t, want: %d %t.", res, loaded, 1, true)
+	}
+}
+
+func TestSyncMapOps(t *testing.T) {
+	cache := New[string, int](2048, 32)
+
+	if res, loaded := cache.LoadOrStore("a", 1); loaded || res != 1 {
+		t.Errorf("Result was incorrect, got: %d %t, want: %d %t.", res, loaded, 1, false)
+	}
+	if res, loaded := cache.LoadOrStore("a", 2); !loaded || res != 1 {
+		t.Errorf("Result was incorrect, got: %d %t, want: %d %t.", res, loaded, 1, true)
+	}
+
+	if cache.CompareAndSwap("a", 2, 3) {
+		t.Errorf("CompareAndSwap swapped a different value")
+	}
+	if !cache.CompareAndSwap("a", 1, 3) || cache.Get("a") != 3 {
+		t.Errorf("CompareAndSwap didn't swap, got: %d, want: %d.", cache.Get("a"), 3)
+	}
+	if cache.CompareAndSwap("missing", 0, 1) || cache.Has("missing") {
+		t.Errorf("CompareAndSwap set a missing key")
+	}
+
+	if cache.CompareAndDelete("a", 1) {
+		t.Errorf("CompareAndDelete deleted a different value")
+	}
+	if !cache.CompareAndDelete("a", 3) || cache.Has("a") {
+		t.Errorf("CompareAndDelete didn't delete")
+	}
+
+	cache.Set("b", 4)
+	if res, loaded := cache.LoadAndDelete("b"); !loaded || res != 4 || cache.Has("b") {
+		t.Errorf("Result was incorrect, got: %d %t, want: %d %t.", res, loaded, 4, true)
+	}
+	if _, loaded := cache.LoadAndDelete("b"); loaded {
+		t.Errorf("LoadAndDelete loaded a missing key")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	cache := New[int, int](2048, 32)
+
+	items := make(map[int]int)
+	keys := make([]int, 0, 200)
+	for i := 0; i < 100; i++ {
+		items[i] = i * 2
+		keys = append(keys, i, i + 1000)
+	}
+	cache.SetMany(items)
+
+	vals := cache.GetMany(keys)
+	if len(vals) != 100 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", len(vals), 100)
+	}
+	for i := 0; i < 100; i++ {
+		if vals[i] != i * 2 {
+			t.Errorf("Result was incorrect, got: %d, want: %d.", vals[i], i * 2)
+		}
+	}
+
+	if n := cache.DeleteMany(keys[:100]); n != 50 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", n, 50)
+	}
+	if n := cache.Count(); n != 50 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", n, 50)
+	}
+}
+
+func TestForEachMode(t *testing.T) {
+	cache := New[int, int](2048, 32)
+	for i := 0; i < 100; i++ {
+		cache.Set(i, i)
+	}
+
+	//the callback writing to the cache would deadlock if the shard was still locked.
+	cache.ForEachMode(kv.IterCopy, func(k int, v int) bool {
+		cache.Set(k, v + 1)
+		return true
+	})
+	for i := 0; i < 100; i++ {
+		if res := cache.Get(i); res != i + 1 {
+			t.Errorf("Result was incorrect, got: %d, want: %d.", res, i + 1)
+		}
+	}
+
+	for _, mode := range []kv.IterMode{kv.IterLocked, kv.IterCopy} {
+		var n int
+		cache.ForEachMode(mode, func(k int, v int) bool {
+			n++
+			return n < 10
+		})
+		if n != 10 {
+			t.Errorf("ForEachMode didn't stop, got: %d calls, want: %d.", n, 10)
+		}
+	}
+
+	var sum int
+	cache.ForEach(func(k int, v int) {
+		sum += v
+	})
+	if sum != 5050 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", sum, 5050)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	cache := New[int, int](8192, 32)
+	for i := 0; i < 100; i++ {
+		cache.Set(i, i)
+	}
+
+	snap := cache.Snapshot()
+	cache.Set(0, 100)
+	cache.Delete(1)
+	cache.Set(200, 200)
+
+	if res := snap.Get(0); res != 0 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", res, 0)
+	}
+	if !snap.Has(1) || snap.Has(200) {
+		t.Errorf("Snapshot saw writes made after it was taken")
+	}
+	if res := cache.Get(0); res != 100 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", res, 100)
+	}
+
+	cache.Clear()
+	if n := snap.Count(); n != 100 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", n, 100)
+	}
+
+	var sum int
+	for _, v := range snap.All() {
+		sum += v
+	}
+	if sum != 4950 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", sum, 4950)
+	}
+}
+
+func TestSnapshotConsistent(t *testing.T) {
+	cache := New[int, int](8192, 32)
+
+	//keys are set in order, so every snapshot must hold a prefix of them.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			cache.Set(i, i)
+		}
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+
+		snap := cache.Snapshot()
+		n := snap.Count()
+		for i := 0; i < n; i++ {
+			if !snap.Has(i) {
+				t.Fatalf("Snapshot of %d items is missing %d.", n, i)
+			}
+		}
+	}
+}
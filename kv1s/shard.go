@@ -5,12 +5,14 @@ package kv1s
 import (
 	"sync"
 
+	"github.com/saintwish/kv"
 	"github.com/saintwish/kv/swiss"
 )
 
 //used internally
 type shard[K comparable, V any] struct {
 	Map *swiss.Map[K, V]
+	shared bool //Map is held by a snapshot, so it's copied before being changed
 	sync.RWMutex //mutex
 }
 
@@ -20,6 +22,20 @@ func newShard[K comparable, V any](size uint64, count uint64) *shard[K, V] {
 	}
 }
 
+//Copies Map if a snapshot holds it, called with the write lock before anything can change Map.
+func (m *shard[K, V]) own() {
+	if m.shared {
+		m.Map = m.Map.Clone()
+		m.shared = false
+	}
+}
+
+//Marks Map as held by a snapshot and returns it, must hold the write lock.
+func (m *shard[K, V]) share() *swiss.Map[K, V] {
+	m.shared = true
+	return m.Map
+}
+
 func (m *shard[K, V]) has(key K) bool {
 	m.RLock()
 
@@ -50,29 +66,92 @@ func (m *shard[K, V]) get(key K) V {
 	return val
 }
 
+//Adds the values of keys that exist to vals.
+func (m *shard[K, V]) getMany(keys []K, vals map[K]V) {
+	m.RLock()
+
+	for _, key := range keys {
+		if ok, v := m.Map.GetHas(key); ok {
+			vals[key] = v
+		}
+	}
+
+	m.RUnlock()
+}
+
 /*--------
 	Other functions
 ----------*/
-func (m *shard[K, V]) set(key K, val V) {
+func (m *shard[K, V]) set(key K, val V, callback func(K, V, kv.Reason)) {
 	m.Lock()
+	m.own()
 
+	ok, old := m.Map.GetHas(key)
 	m.Map.Set(key, val)
+	if ok {
+		callback(key, old, kv.Replaced)
+	}
+
+	m.Unlock()
+}
+
+//Sets key only if it's missing, returns false if it wasn't set.
+func (m *shard[K, V]) add(key K, val V) (ok bool) {
+	m.Lock()
+	m.own()
+
+	if ok = !m.Map.Has(key); ok {
+		m.Map.Set(key, val)
+	}
 
 	m.Unlock()
+
+	return
 }
 
-func (m *shard[K, V]) update(key K, val V) {
+//Sets key only if it exists, returns false if it wasn't set.
+func (m *shard[K, V]) update(key K, val V, callback func(K, V, kv.Reason)) (ok bool) {
 	m.Lock()
+	m.own()
 
-	if ok := m.Map.Has(key); ok {
+	var old V
+	if ok, old = m.Map.GetHas(key); ok {
 		m.Map.Set(key, val)
+		callback(key, old, kv.Replaced)
 	}
 
 	m.Unlock()
+
+	return
+}
+
+//Calls f with the value of key and applies its result.
+func (m *shard[K, V]) compute(key K, f func(V, bool) (V, kv.ComputeOp), callback func(K, V, kv.Reason)) (V, bool) {
+	m.Lock()
+	m.own()
+	defer m.Unlock()
+
+	exists, old := m.Map.GetHas(key)
+	val, op := f(old, exists)
+	switch op {
+	case kv.StoreOp:
+		m.Map.Set(key, val)
+		if exists {
+			callback(key, old, kv.Replaced)
+		}
+		return val, true
+	case kv.DeleteOp:
+		m.Map.Delete(key)
+		var zero V
+		return zero, false
+	}
+
+	return old, exists
 }
 
 func (m *shard[K, V]) delete(key K) bool {
 	m.Lock()
+	m.own()
 
 	ok,_ := m.Map.Delete(key)
 
@@ -81,31 +160,96 @@ func (m *shard[K, V]) delete(key K) bool {
 	return ok
 }
 
-func (m *shard[K, V]) deleteCallback(key K, callback func(K, V)) bool {
+func (m *shard[K, V]) setMany(keys []K, items map[K]V, callback func(K, V, kv.Reason)) {
 	m.Lock()
+	m.own()
+
+	for _, key := range keys {
+		ok, old := m.Map.GetHas(key)
+		m.Map.Set(key, items[key])
+		if ok {
+			callback(key, old, kv.Replaced)
+		}
+	}
+
+	m.Unlock()
+}
+
+func (m *shard[K, V]) deleteMany(keys []K) (count int) {
+	m.Lock()
+	m.own()
+
+	for _, key := range keys {
+		if ok, _ := m.Map.Delete(key); ok {
+			count++
+		}
+	}
+
+	m.Unlock()
+
+	return
+}
+
+func (m *shard[K, V]) deleteCallback(key K, callback func(K, V, kv.Reason)) bool {
+	m.Lock()
+	m.own()
 
 	ok, val := m.Map.Delete(key)
-	callback(key, val)
+	if ok {
+		callback(key, val, kv.Deleted)
+	}
 
 	m.Unlock()
 
 	return ok
 }
 
+//Calls f for every item under the read lock, returns false if f stopped.
+func (m *shard[K, V]) iterate(f func(K, V) bool) (cont bool) {
+	m.RLock()
+
+	cont = true
+	m.Map.Iter(func(key K, val V) (stop bool) {
+		cont = f(key, val)
+		return !cont
+	})
+
+	m.RUnlock()
+
+	return
+}
+
+//Appends every item to keys and vals under the read lock.
+func (m *shard[K, V]) collect(keys []K, vals []V) ([]K, []V) {
+	m.RLock()
+
+	m.Map.Iter(func(key K, val V) (stop bool) {
+		keys = append(keys, key)
+		vals = append(vals, val)
+		return
+	})
+
+	m.RUnlock()
+
+	return keys, vals
+}
+
 func (m *shard[K, V]) clear() {
+	m.Lock()
+	m.own()
+
 	m.Map.Clear()
+
+	m.Unlock()
 }
 
-func (m *shard[K, V]) flush(callback func(K, V)) {
+func (m *shard[K, V]) flush(callback func(K, V, kv.Reason)) {
 	m.Lock()
+	m.own()
 
 	m.Map.Iter(func(key K, val V) (stop bool) {
-		callback(key, val)
+		callback(key, val, kv.Flushed)
 		m.Map.Delete(key)
-		
-		if stop {
-			return
-		}
 
 		return
 	})
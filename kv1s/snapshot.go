@@ -0,0 +1,85 @@
+package kv1s
+
+import (
+	"iter"
+
+	"github.com/saintwish/kv/swiss"
+)
+
+// An immutable view of every item of a cache as of the moment Snapshot was called. It's safe
+// for concurrent use and reading it never locks the cache.
+type Snapshot[K comparable, V any] struct {
+	maps []*swiss.Map[K, V]
+	hash func(K) uint64
+}
+
+// Takes a consistent snapshot of the cache. Every shard is locked at once only long enough to
+// mark its map as shared, after which the first write to a shard copies its map instead of
+// changing the one held by the snapshot. The snapshot has every write that finished before
+// Snapshot was called and none that started after it returned.
+func (c *Cache[K, V]) Snapshot() *Snapshot[K, V] {
+	snap := &Snapshot[K, V]{
+		maps: make([]*swiss.Map[K, V], len(c.shards)),
+		hash: c.hash,
+	}
+
+	for i := 0; i < len(c.shards); i++ {
+		c.shards[i].Lock()
+	}
+
+	for i := 0; i < len(c.shards); i++ {
+		snap.maps[i] = c.shards[i].share()
+	}
+
+	for i := 0; i < len(c.shards); i++ {
+		c.shards[i].Unlock()
+	}
+
+	return snap
+}
+
+// Gets the value of key and if it exists.
+func (s *Snapshot[K, V]) GetHas(key K) (V, bool) {
+	m := s.maps[s.hash(key) % uint64(len(s.maps))]
+	ok, v := m.GetHas(key)
+	return v, ok
+}
+
+// Gets the number of items in the snapshot.
+func (s *Snapshot[K, V]) Count() (count int) {
+	for _, m := range s.maps {
+		count += m.Count()
+	}
+	return
+}
+
+// Calls f for every item until it returns false, f may use the cache.
+func (s *Snapshot[K, V]) Range(f func(key K, val V) bool) {
+	for _, m := range s.maps {
+		stopped := false
+		m.Iter(func(key K, v V) bool {
+			stopped = !f(key, v)
+			return stopped
+		})
+
+		if stopped {
+			return
+		}
+	}
+}
+
+// Gets the value of key, the zero value if it's missing.
+func (s *Snapshot[K, V]) Get(key K) V {
+	val, _ := s.GetHas(key)
+	return val
+}
+
+func (s *Snapshot[K, V]) Has(key K) bool {
+	_, ok := s.GetHas(key)
+	return ok
+}
+
+// Returns an iterator over the items for use with range, see Range.
+func (s *Snapshot[K, V]) All() iter.Seq2[K, V] {
+	return s.Range
+}
@@ -0,0 +1,128 @@
+package kv2
+
+import (
+	"github.com/saintwish/kv/internal/list"
+)
+
+type arcEntry[K comparable] struct {
+	key K
+	frequent bool //in t2 rather than t1
+}
+
+type arcGhost struct {
+	handle int32
+	frequent bool //in b2 rather than b1
+}
+
+// ARC, items seen once live in t1 and items seen again in t2, both in LRU order. Evicted keys
+// are remembered in the ghost lists b1 and b2, and a miss on a ghost moves the target size of
+// t1 towards the list that would have kept it, tuning itself between recency and frequency.
+type ARC[K comparable] struct {
+	nodes *list.Arena[arcEntry[K]]
+	t1, t2 list.List //each from least to most recently used
+	target int //size t1 is aimed at
+	capacity int
+
+	ghosts *list.Arena[K]
+	b1, b2 list.List //each from oldest to newest
+	ghostKeys map[K]arcGhost
+}
+
+func NewARC[K comparable](capacity int) Policy[K] {
+	return &ARC[K]{
+		nodes: list.NewArena[arcEntry[K]](capacity),
+		capacity: max(capacity, 1),
+		ghosts: list.NewArena[K](capacity),
+		ghostKeys: make(map[K]arcGhost, capacity),
+	}
+}
+
+func (p *ARC[K]) list(frequent bool) *list.List {
+	if frequent {
+		return &p.t2
+	}
+
+	return &p.t1
+}
+
+func (p *ARC[K]) ghostList(frequent bool) *list.List {
+	if frequent {
+		return &p.b2
+	}
+
+	return &p.b1
+}
+
+// Adds key to t1, or to t2 if it's a ghost, in which case the target grows on a b1 hit and
+// shrinks on a b2 one, by more the smaller that ghost list is.
+func (p *ARC[K]) OnInsert(key K) int32 {
+	g, ok := p.ghostKeys[key]
+	if !ok {
+		return p.nodes.PushBack(&p.t1, arcEntry[K]{key: key})
+	}
+
+	if g.frequent {
+		p.target = max(p.target - max(p.b1.Len()/p.b2.Len(), 1), 0)
+	} else {
+		p.target = min(p.target + max(p.b2.Len()/p.b1.Len(), 1), p.capacity)
+	}
+	p.forget(key, g)
+
+	return p.nodes.PushBack(&p.t2, arcEntry[K]{key: key, frequent: true})
+}
+
+func (p *ARC[K]) OnAccess(h int32) {
+	e := p.nodes.Value(h)
+	if e.frequent {
+		p.nodes.MoveToBack(&p.t2, h)
+		return
+	}
+
+	p.nodes.Unlink(&p.t1, h)
+	e.frequent = true
+	p.nodes.LinkBack(&p.t2, h)
+}
+
+func (p *ARC[K]) OnDelete(h int32) {
+	p.nodes.Remove(p.list(p.nodes.Value(h).frequent), h)
+}
+
+// Gets the least recently used item of t1 if it's bigger than the target, of t2 otherwise,
+// and remembers it as a ghost.
+func (p *ARC[K]) Victim() K {
+	frequent := p.t1.Len() == 0 || (p.t1.Len() <= p.target && p.t2.Len() > 0)
+	e := p.nodes.Value(p.nodes.Front(p.list(frequent)))
+	p.remember(e.key, frequent)
+
+	return e.key
+}
+
+// Adds key to b1 or b2, keeping the ghosts at most as many as the capacity by dropping the
+// oldest of b1 if t1 and b1 together are over it, of b2 otherwise.
+func (p *ARC[K]) remember(key K, frequent bool) {
+	if p.b1.Len() + p.b2.Len() >= p.capacity {
+		drop := p.b1.Len() == 0 || (p.t1.Len() + p.b1.Len() < p.capacity && p.b2.Len() > 0)
+		g := p.ghosts.Front(p.ghostList(drop))
+		p.forget(*p.ghosts.Value(g), arcGhost{handle: g, frequent: drop})
+	}
+
+	p.ghostKeys[key] = arcGhost{
+		handle: p.ghosts.PushBack(p.ghostList(frequent), key),
+		frequent: frequent,
+	}
+}
+
+func (p *ARC[K]) forget(key K, g arcGhost) {
+	p.ghosts.Remove(p.ghostList(g.frequent), g.handle)
+	delete(p.ghostKeys, key)
+}
+
+func (p *ARC[K]) Clear() {
+	p.nodes.Clear()
+	p.t1, p.t2 = list.List{}, list.List{}
+	p.target = 0
+
+	p.ghosts.Clear()
+	p.b1, p.b2 = list.List{}, list.List{}
+	clear(p.ghostKeys)
+}
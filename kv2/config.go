@@ -0,0 +1,73 @@
+package kv2
+
+import (
+	"fmt"
+
+	"github.com/dolthub/maphash"
+	"github.com/saintwish/kv"
+)
+
+// Settings of a cache made by NewFromConfig, only Capacity and Shards are required.
+type Config[K comparable, V any] struct {
+	Capacity uint64 //size of the cache, split evenly between the shards
+	Shards uint64 //number of shards, can't be more than Capacity
+	MaxCost int64 //total cost of the items the cache can hold, shared by every shard, 0 for no limit
+	Weigher func(K, V) int64 //gives the cost of an item, every item costs 1 if nil. Negative costs count as 0.
+	Policy func(capacity int) Policy[K] //makes the eviction policy of each shard, NewLRU by default
+
+	OnEvicted func(K, V)
+	OnEvictedReason func(K, V, kv.Reason)
+	Loader kv.Loader[K, V]
+
+	Hasher func(K) uint64 //picks the shard of keys, a randomly seeded maphash by default
+	Metrics kv.Metrics //told about hits, misses and evictions if set
+}
+
+func (cfg *Config[K, V]) validate() error {
+	switch {
+	case cfg.Shards == 0:
+		return fmt.Errorf("kv2: shard count must be greater than zero: %w", kv.ErrInvalidConfig)
+	case cfg.Shards > cfg.Capacity:
+		return fmt.Errorf("kv2: shard count must be smaller than cache size: %w", kv.ErrInvalidConfig)
+	case cfg.MaxCost < 0:
+		return fmt.Errorf("kv2: max cost can't be negative: %w", kv.ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// Creates a cache from cfg, returning an error wrapping kv.ErrInvalidConfig instead of
+// panicking if it's invalid.
+func NewFromConfig[K comparable, V any](cfg Config[K, V]) (*Cache[K, V], error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	if cfg.Hasher == nil {
+		cfg.Hasher = maphash.NewHasher[K]().Hash
+	}
+
+	if cfg.Policy == nil {
+		cfg.Policy = NewLRU[K]
+	}
+
+	cache := &Cache[K, V] {
+		shards: make([]*shardCapacity[K, V], cfg.Shards),
+		shardCount: cfg.Shards,
+		hash: cfg.Hasher,
+		metrics: cfg.Metrics,
+		maxCost: cfg.MaxCost,
+
+		OnEvicted: cfg.OnEvicted,
+		OnEvictedReason: cfg.OnEvictedReason,
+		Loader: cfg.Loader,
+	}
+
+	for i := 0; i < int(cfg.Shards); i++ {
+		cache.shards[i] = newShardCapacity[K, V](cfg.Capacity, cfg.Shards, cfg.Policy, &cache.cost)
+		cache.shards[i].weigher = cfg.Weigher
+		cache.shards[i].maxCost = cfg.MaxCost
+	}
+
+	return cache, nil
+}
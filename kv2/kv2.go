@@ -1,69 +1,157 @@
 package kv2
 
 import (
+	"context"
 	"fmt"
+	"iter"
+	"sync/atomic"
 	
-	"github.com/dolthub/maphash"
+	"github.com/saintwish/kv"
+	"github.com/saintwish/kv/internal/flight"
 )
 
 type Cache[K comparable, V any] struct {
 	shards []*shardCapacity[K, V]
 	shardCount uint64
-	hash maphash.Hasher[K]
+	hash func(K) uint64
+	metrics kv.Metrics
+	cost atomic.Int64 //total cost of the items, see Config.Weigher
+	maxCost int64 //see Config.MaxCost
 
 	OnEvicted func(K, V) //function that's called when cached item is deleted by the system
-}
+	OnEvictedReason func(K, V, kv.Reason) //same as OnEvicted but also told why, called for replaced items too
+	Loader kv.Loader[K, V] //function that's called by GetOrLoad to load missing items
 
-func New[K comparable, V any](sz uint64, sc uint64) *Cache[K, V] {
-	if sc > sz {
-		panic("kv2: shard count must be smaller than cache size!")
-	}
+	flight flight.Group[K, V]
+}
 
-	cache := Cache[K, V] {}
-	cache.shards = make([]*shardCapacity[K, V], sc)
-	cache.hash = maphash.NewHasher[K]()
-	cache.shardCount = sc
+var _ kv.Cache[string, any] = (*Cache[string, any])(nil)
 
-	for i := 0; i < int(sc); i++ {
-		cache.shards[i] = newShardCapacity[K, V](sz, sc)
+// Panics if the shard count is bigger than the size, use NewFromConfig to get an error instead.
+func New[K comparable, V any](sz uint64, sc uint64) *Cache[K, V] {
+	cache, err := NewFromConfig(Config[K, V]{
+		Capacity: sz,
+		Shards: sc,
+	})
+	if err != nil {
+		panic(err)
 	}
 
-	return &cache
+	return cache
 }
 
 func (c *Cache[K, V]) getShardIndex(key K) uint64 {
-	sum := c.hash.Hash(key)
+	sum := c.hash(key)
 
 	return sum % c.shardCount
 }
 
 func (c *Cache[K, V]) getShard(key K) *shardCapacity[K, V] {
-	sum := c.hash.Hash(key)
+	sum := c.hash(key)
 	return c.shards[sum%c.shardCount]
 }
 
+// Tells the metrics if a read was a hit.
+func (c *Cache[K, V]) record(hit bool) {
+	if c.metrics == nil {
+		return
+	}
+
+	if hit {
+		c.metrics.Hit()
+	} else {
+		c.metrics.Miss()
+	}
+}
+
+// Evicts items round robin from the shards, starting with the one after shard i, until the cache
+// is back within its MaxCost. Each shard gives up its policy's victim, so recently used items
+// are kept whichever shard they're in, but shard i always keeps one item so key isn't evicted
+// just for being alone in its shard. Returns false if key, from shard i, was evicted.
+func (c *Cache[K, V]) fit(i uint64, key K) (kept bool) {
+	kept = true
+	for c.maxCost > 0 && c.cost.Load() > c.maxCost {
+		shed := false
+		for j := uint64(1); j <= c.shardCount; j++ {
+			n := (i + j) % c.shardCount
+			keep := 0
+			if n == i {
+				keep = 1
+			}
+
+			if k, ok := c.shards[n].shed(keep, c.evicted); ok {
+				shed = true
+				if n == i && k == key {
+					kept = false
+				}
+			}
+		}
+
+		if !shed {
+			return
+		}
+	}
+
+	return
+}
+
 func (c *Cache[K, V]) SetOnEvicted(f func(K, V)) {
 	c.OnEvicted = f
 }
 
+func (c *Cache[K, V]) SetOnEvictedReason(f func(K, V, kv.Reason)) {
+	c.OnEvictedReason = f
+}
+
+func (c *Cache[K, V]) SetLoader(f kv.Loader[K, V]) {
+	c.Loader = f
+}
+
+// Calls the eviction callbacks, OnEvicted keeps its old behaviour of not being called for replaced items.
+func (c *Cache[K, V]) evicted(key K, val V, reason kv.Reason) {
+	if c.metrics != nil {
+		c.metrics.Evict(reason)
+	}
+
+	if c.OnEvictedReason != nil {
+		c.OnEvictedReason(key, val, reason)
+	}
+
+	if c.OnEvicted != nil && reason != kv.Replaced {
+		c.OnEvicted(key, val)
+	}
+}
+
+// Gets the value of key without marking it as used, see GetRenew.
 func (c *Cache[K, V]) Get(key K) V {
-	shard := c.getShard(key)
-	return shard.get(key)
+	val, _ := c.GetHas(key)
+	return val
 }
 
+// Gets the value of key, telling the eviction policy it was used.
 func (c *Cache[K, V]) GetRenew(key K) V {
-	shard := c.getShard(key)
-	return shard.getRenew(key)
+	val, _ := c.GetHasRenew(key)
+	return val
 }
 
+// Gets the value of key and if it exists without marking it as used, only taking the shard's
+// read lock.
 func (c *Cache[K, V]) GetHas(key K) (V, bool) {
 	shard := c.getShard(key)
-	return shard.getHas(key)
+	val, ok := shard.getHas(key)
+	c.record(ok)
+
+	return val, ok
 }
 
+// Gets the value of key and if it exists, telling the eviction policy it was used. Takes the
+// shard's write lock unless the policy is a ReadPolicy.
 func (c *Cache[K, V]) GetHasRenew(key K) (V, bool) {
 	shard := c.getShard(key)
-	return shard.getHasRenew(key)
+	val, ok := shard.getHasRenew(key)
+	c.record(ok)
+
+	return val, ok
 }
 
 func (c *Cache[K, V]) Has(key K) bool {
@@ -71,38 +159,148 @@ func (c *Cache[K, V]) Has(key K) bool {
 	return shard.has(key)
 }
 
+// Gets the value of key, calling Loader to load and cache it if it's missing. Concurrent
+// misses of the same key share a single call of Loader and its errors are returned without
+// caching anything. If ctx is done first its error is returned, the load carries on for the
+// other callers and still gets cached.
+func (c *Cache[K, V]) GetOrLoad(ctx context.Context, key K) (V, error) {
+	if val, ok := c.GetHas(key); ok {
+		return val, nil
+	}
+
+	if c.Loader == nil {
+		var val V
+		return val, kv.ErrNoLoader
+	}
+
+	return c.flight.Do(ctx, key, func(ctx context.Context) (V, error) {
+		//another load may have finished between the miss and this one starting.
+		if val, ok := c.getShard(key).getHas(key); ok {
+			return val, nil
+		}
+
+		val, err := c.Loader(ctx, key)
+		if err == nil {
+			c.Set(key, val)
+		}
+
+		return val, err
+	})
+}
+
+// Sets key to val. Items costing more than MaxCost aren't stored, OnEvictedReason is called
+// with kv.Capacity for them instead.
 func (c *Cache[K, V]) Set(key K, val V) {
-	shard := c.getShard(key)
-	shard.set(key, val, c.OnEvicted)
+	i := c.getShardIndex(key)
+	c.shards[i].set(key, val, c.evicted)
+	c.fit(i, key)
 }
 
+// Sets key to val if it's missing, errors if it exists or if it doesn't fit in MaxCost.
 func (c *Cache[K, V]) Add(key K, val V) error {
-	shard := c.getShard(key)
-	if shard.has(key) {
+	i := c.getShardIndex(key)
+	exists, ok := c.shards[i].add(key, val, c.evicted)
+	if exists {
 		return fmt.Errorf("kv2: Data already exists with given key %T", key)
 	}
 
-	shard.set(key, val, c.OnEvicted)
+	if !c.fit(i, key) || !ok {
+		return fmt.Errorf("kv2: Data doesn't fit in the max cost with given key %T", key)
+	}
+
 	return nil
 }
 
+// Sets key to val if it exists, errors if it's missing or if it was evicted to fit in MaxCost.
 func (c *Cache[K, V]) Update(key K, val V) error {
-	shard := c.getShard(key)
-	if !shard.has(key) {
+	i := c.getShardIndex(key)
+	exists, ok := c.shards[i].update(key, val, c.evicted)
+	if !exists {
 		return fmt.Errorf("kv2: Data doesn't exists with given key %T", key)
 	}
 
-	shard.update(key, val)
+	if !c.fit(i, key) || !ok {
+		return fmt.Errorf("kv2: Data doesn't fit in the max cost with given key %T", key)
+	}
+
 	return nil
 }
 
+// Same as Set.
 func (c *Cache[K, V]) SetOrUpdate(key K, val V) {
-	shard := c.getShard(key)
-	if shard.has(key) {
-		shard.update(key, val)
-	}else{
-		shard.set(key, val, c.OnEvicted)
+	c.Set(key, val)
+}
+
+// Calls f with the current value of key, or the zero value and false if it's missing, and
+// applies the returned op. The shard of key stays locked while f runs, so f must be quick
+// and must not use the cache. Returns the value of key afterwards and if it exists.
+func (c *Cache[K, V]) Compute(key K, f func(old V, exists bool) (V, kv.ComputeOp)) (V, bool) {
+	i := c.getShardIndex(key)
+	val, ok := c.shards[i].compute(key, f, c.evicted)
+	if !c.fit(i, key) && ok {
+		var zero V
+		return zero, false
 	}
+
+	return val, ok
+}
+
+// Sets key to the value returned by f if it's missing, f being called under the shard lock.
+// Returns the value of key and true if it already existed.
+func (c *Cache[K, V]) ComputeIfAbsent(key K, f func() V) (actual V, loaded bool) {
+	actual, _ = c.Compute(key, func(old V, exists bool) (V, kv.ComputeOp) {
+		if loaded = exists; exists {
+			return old, kv.CancelOp
+		}
+
+		return f(), kv.StoreOp
+	})
+
+	return
+}
+
+// Gets the value of key if it exists, otherwise sets it to val. Loaded is true if the value
+// was already there.
+func (c *Cache[K, V]) LoadOrStore(key K, val V) (actual V, loaded bool) {
+	return c.ComputeIfAbsent(key, func() V {
+		return val
+	})
+}
+
+// Deletes key, returning its value and if it existed.
+func (c *Cache[K, V]) LoadAndDelete(key K) (val V, loaded bool) {
+	c.Compute(key, func(old V, exists bool) (V, kv.ComputeOp) {
+		val, loaded = old, exists
+		return old, kv.DeleteOp
+	})
+
+	return
+}
+
+// Sets key to new if its value is equal to old, panics like sync.Map if V isn't comparable.
+func (c *Cache[K, V]) CompareAndSwap(key K, old V, new V) (swapped bool) {
+	c.Compute(key, func(cur V, exists bool) (V, kv.ComputeOp) {
+		if swapped = exists && any(cur) == any(old); swapped {
+			return new, kv.StoreOp
+		}
+
+		return cur, kv.CancelOp
+	})
+
+	return
+}
+
+// Deletes key if its value is equal to old, panics like sync.Map if V isn't comparable.
+func (c *Cache[K, V]) CompareAndDelete(key K, old V) (deleted bool) {
+	c.Compute(key, func(cur V, exists bool) (V, kv.ComputeOp) {
+		if deleted = exists && any(cur) == any(old); deleted {
+			return cur, kv.DeleteOp
+		}
+
+		return cur, kv.CancelOp
+	})
+
+	return
 }
 
 func (c *Cache[K, V]) Delete(key K) bool {
@@ -112,7 +310,7 @@ func (c *Cache[K, V]) Delete(key K) bool {
 
 func (c *Cache[K, V]) DeleteCallback(key K) bool {
 	shard := c.getShard(key)
-	return shard.deleteCallback(key, c.OnEvicted)
+	return shard.deleteCallback(key, c.evicted)
 }
 
 func (c *Cache[K, V]) ShardCount() uint64 {
@@ -134,11 +332,27 @@ func (c *Cache[K, V]) GetShardCapacity(key K) int {
 	return shard.Map.Capacity()
 }
 
+// Gets the total cost of the items in the cache, their count if there's no Config.Weigher.
+func (c *Cache[K, V]) Cost() int64 {
+	return c.cost.Load()
+}
+
+// Gets the current amount of elements in the cache.
+func (c *Cache[K, V]) Count() (count int) {
+	for i := 0; i < len(c.shards); i++ {
+		shard := c.shards[i]
+		shard.RLock()
+		count = count + shard.Map.Count()
+		shard.RUnlock()
+	}
+	return
+}
+
 // Clears the cache with calling OnEviction callback
 func (c *Cache[K, V]) Flush() {
 	for i := 0; i < len(c.shards); i++ {
 		shard := c.shards[i]
-		shard.flush(c.OnEvicted)
+		shard.flush(c.evicted)
 	}
 }
 
@@ -150,20 +364,63 @@ func (c *Cache[K, V]) Clear() {
 	}
 }
 
-func (c *Cache[K, V]) ForEach(f func(key K, val V)) {
+// Calls f for every item until it returns false. Shards are read locked one at a time
+// while f is called for their items, so f must not write to the cache.
+func (c *Cache[K, V]) Range(f func(key K, val V) bool) {
 	for i := 0; i < len(c.shards); i++ {
-		shard := c.shards[i]
-		shard.Lock()
-		defer shard.Unlock()
+		if !c.shards[i].iterate(f) {
+			return
+		}
+	}
+}
 
-		shard.Map.Iter(func(key K, val item[V]) (stop bool) {
-			f(key, val.Object)
+// Returns an iterator over the items for use with range, it works like Range so breaking
+// out of the loop stops it and the loop body must not write to the cache.
+func (c *Cache[K, V]) All() iter.Seq2[K, V] {
+	return c.Range
+}
 
-			if stop {
-				return
-			}
+// Returns an iterator over the keys, see All.
+func (c *Cache[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		c.Range(func(key K, _ V) bool {
+			return yield(key)
+		})
+	}
+}
 
-			return
+// Returns an iterator over the values, see All.
+func (c *Cache[K, V]) Values() iter.Seq[V] {
+	return func(yield func(V) bool) {
+		c.Range(func(_ K, val V) bool {
+			return yield(val)
 		})
 	}
-}
\ No newline at end of file
+}
+
+// Calls f for every item until it returns false, see kv.IterMode for how shards are locked.
+func (c *Cache[K, V]) ForEachMode(mode kv.IterMode, f func(key K, val V) bool) {
+	if mode == kv.IterLocked {
+		c.Range(f)
+		return
+	}
+
+	var keys []K
+	var vals []V
+	for i := 0; i < len(c.shards); i++ {
+		keys, vals = c.shards[i].collect(keys[:0], vals[:0])
+		for j := range keys {
+			if !f(keys[j], vals[j]) {
+				return
+			}
+		}
+	}
+}
+
+// Calls f for every item, copying one shard at a time so f may use the cache.
+func (c *Cache[K, V]) ForEach(f func(key K, val V)) {
+	c.ForEachMode(kv.IterCopy, func(key K, val V) bool {
+		f(key, val)
+		return true
+	})
+}
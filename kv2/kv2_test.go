@@ -1,8 +1,16 @@
 package kv2
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/saintwish/kv"
+	"github.com/saintwish/kv/kvtest"
 )
 
 func TestSetGet_KeyString(t *testing.T) {
@@ -23,6 +31,12 @@ func TestSetGet_KeyInt(t *testing.T) {
 	}
 }
 
+func TestConformance(t *testing.T) {
+	kvtest.Run(t, func() kv.Cache[string, int] {
+		return New[string, int](2048, 32)
+	})
+}
+
 func TestFlush(t *testing.T) {
 	cache := New[int, string](2048, 32)
 	cache.SetOnEvicted(func(k int, v string){
@@ -35,4 +49,744 @@ func TestFlush(t *testing.T) {
 	cache.Set(5465, "leet haxiors3")
 
 	cache.Flush()
-}
\ No newline at end of file
+}
+
+func TestEvictedReason(t *testing.T) {
+	cache := New[int, string](2048, 32)
+	reasons := map[int][]kv.Reason{}
+	cache.SetOnEvictedReason(func(k int, v string, r kv.Reason) {
+		reasons[k] = append(reasons[k], r)
+	})
+
+	cache.Set(1, "one")
+	cache.Set(1, "uno")
+	cache.Set(2, "two")
+	cache.DeleteCallback(2)
+	cache.DeleteCallback(3)
+	cache.Flush()
+
+	if res := reasons[1]; len(res) != 2 || res[0] != kv.Replaced || res[1] != kv.Flushed {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", res, []kv.Reason{kv.Replaced, kv.Flushed})
+	}
+	if res := reasons[2]; len(res) != 1 || res[0] != kv.Deleted {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", res, []kv.Reason{kv.Deleted})
+	}
+	if res := reasons[3]; len(res) != 0 {
+		t.Errorf("Callback called for missing key, got: %v.", res)
+	}
+}
+
+func TestGetOrLoad(t *testing.T) {
+	cache := New[int, string](2048, 32)
+
+	var calls atomic.Int32
+	cache.SetLoader(func(ctx context.Context, k int) (string, error) {
+		calls.Add(1)
+		time.Sleep(20*time.Millisecond)
+		return fmt.Sprint(k), nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if res, err := cache.GetOrLoad(context.Background(), 1337); err != nil || res != "1337" {
+				t.Errorf("Result was incorrect, got: %s %v, want: %s.", res, err, "1337")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Loader was called %d times, want: %d.", n, 1)
+	}
+	if res := cache.Get(1337); res != "1337" {
+		t.Errorf("Loaded value was not cached, got: %s.", res)
+	}
+}
+
+func TestGetOrLoadError(t *testing.T) {
+	cache := New[int, string](2048, 32)
+
+	if _, err := cache.GetOrLoad(context.Background(), 1); !errors.Is(err, kv.ErrNoLoader) {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", err, kv.ErrNoLoader)
+	}
+
+	fail := errors.New("backend down")
+	cache.SetLoader(func(ctx context.Context, k int) (string, error) {
+		return "", fail
+	})
+
+	if _, err := cache.GetOrLoad(context.Background(), 1); !errors.Is(err, fail) {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", err, fail)
+	}
+	if cache.Has(1) {
+		t.Errorf("Failed load was cached")
+	}
+
+	block := make(chan struct{})
+	cache.SetLoader(func(ctx context.Context, k int) (string, error) {
+		<-block
+		return "late", nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := cache.GetOrLoad(ctx, 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", err, context.DeadlineExceeded)
+	}
+
+	close(block)
+	if res, err := cache.GetOrLoad(context.Background(), 2); err != nil || res != "late" {
+		t.Errorf("Result was incorrect, got: %s %v, want: %s.", res, err, "late")
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	bad := []Config[int, int]{
+		{Capacity: 2048, Shards: 0},
+		{Capacity: 16, Shards: 32},
+	}
+
+	for _, cfg := range bad {
+		if _, err := NewFromConfig(cfg); !errors.Is(err, kv.ErrInvalidConfig) {
+			t.Errorf("Result was incorrect, got: %v, want: %v.", err, kv.ErrInvalidConfig)
+		}
+	}
+
+	cache, err := NewFromConfig(Config[int, int]{Capacity: 2048, Shards: 32})
+	if err != nil {
+		t.Fatalf("Valid config was rejected: %v", err)
+	}
+	cache.Set(1, 1)
+	if res := cache.Get(1); res != 1 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", res, 1)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	stats := &kv.Stats{}
+	cache, err := NewFromConfig(Config[int, int]{
+		Capacity: 2048,
+		Shards: 32,
+		Metrics: stats,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set(1, 1)
+	cache.Set(2, 2)
+	cache.Get(1)
+	cache.Get(3)
+	cache.Set(1, 10)
+	cache.DeleteCallback(2)
+
+	if stats.Hits() != 1 || stats.Misses() != 1 {
+		t.Errorf("Result was incorrect, got: %d hits %d misses, want: %d hits %d misses.", stats.Hits(), stats.Misses(), 1, 1)
+	}
+	if n := stats.Evictions(kv.Replaced); n != 1 {
+		t.Errorf("Result was incorrect, got: %d replaced, want: %d.", n, 1)
+	}
+	if n := stats.Evictions(kv.Deleted); n != 1 {
+		t.Errorf("Result was incorrect, got: %d deleted, want: %d.", n, 1)
+	}
+}
+
+func TestCompute(t *testing.T) {
+	cache := New[string, int](2048, 32)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				cache.Compute("counter", func(old int, exists bool) (int, kv.ComputeOp) {
+					return old + 1, kv.StoreOp
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if res := cache.Get("counter"); res != 8000 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", res, 8000)
+	}
+
+	if res, ok := cache.Compute("counter", func(old int, exists bool) (int, kv.ComputeOp) {
+		return 0, kv.CancelOp
+	}); !ok || res != 8000 {
+		t.Errorf("Result was incorrect, got: %d %t, want: %d %t.", res, ok, 8000, true)
+	}
+
+	if _, ok := cache.Compute("counter", func(old int, exists bool) (int, kv.ComputeOp) {
+		return 0, kv.DeleteOp
+	}); ok || cache.Has("counter") {
+		t.Errorf("Compute didn't delete the key")
+	}
+
+	if res, loaded := cache.ComputeIfAbsent("lazy", func() int { return 1 }); loaded || res != 1 {
+		t.Errorf("Result was incorrect, got: %d %t, want: %d %t.", res, loaded, 1, false)
+	}
+	if res, loaded := cache.ComputeIfAbsent("lazy", func() int { return 2 }); !loaded || res != 1 {
+		t.Errorf("Result was incorrect, got: %d %t, want: %d %t.", res, loaded, 1, true)
+	}
+}
+
+func TestSyncMapOps(t *testing.T) {
+	cache := New[string, int](2048, 32)
+
+	if res, loaded := cache.LoadOrStore("a", 1); loaded || res != 1 {
+		t.Errorf("Result was incorrect, got: %d %t, want: %d %t.", res, loaded, 1, false)
+	}
+	if res, loaded := cache.LoadOrStore("a", 2); !loaded || res != 1 {
+		t.Errorf("Result was incorrect, got: %d %t, want: %d %t.", res, loaded, 1, true)
+	}
+
+	if cache.CompareAndSwap("a", 2, 3) {
+		t.Errorf("CompareAndSwap swapped a different value")
+	}
+	if !cache.CompareAndSwap("a", 1, 3) || cache.Get("a") != 3 {
+		t.Errorf("CompareAndSwap didn't swap, got: %d, want: %d.", cache.Get("a"), 3)
+	}
+	if cache.CompareAndSwap("missing", 0, 1) || cache.Has("missing") {
+		t.Errorf("CompareAndSwap set a missing key")
+	}
+
+	if cache.CompareAndDelete("a", 1) {
+		t.Errorf("CompareAndDelete deleted a different value")
+	}
+	if !cache.CompareAndDelete("a", 3) || cache.Has("a") {
+		t.Errorf("CompareAndDelete didn't delete")
+	}
+
+	cache.Set("b", 4)
+	if res, loaded := cache.LoadAndDelete("b"); !loaded || res != 4 || cache.Has("b") {
+		t.Errorf("Result was incorrect, got: %d %t, want: %d %t.", res, loaded, 4, true)
+	}
+	if _, loaded := cache.LoadAndDelete("b"); loaded {
+		t.Errorf("LoadAndDelete loaded a missing key")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	cache := New[int, int](2048, 32)
+
+	items := make(map[int]int)
+	keys := make([]int, 0, 200)
+	for i := 0; i < 100; i++ {
+		items[i] = i * 2
+		keys = append(keys, i, i + 1000)
+	}
+	cache.SetMany(items)
+
+	vals := cache.GetMany(keys)
+	if len(vals) != 100 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", len(vals), 100)
+	}
+	for i := 0; i < 100; i++ {
+		if vals[i] != i * 2 {
+			t.Errorf("Result was incorrect, got: %d, want: %d.", vals[i], i * 2)
+		}
+	}
+
+	if n := cache.DeleteMany(keys[:100]); n != 50 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", n, 50)
+	}
+	if n := cache.Count(); n != 50 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", n, 50)
+	}
+}
+
+func TestSetManyEvicts(t *testing.T) {
+	cache := New[int, int](64, 1)
+
+	var replaced, evicted int
+	cache.SetOnEvictedReason(func(k int, v int, r kv.Reason) {
+		switch r {
+		case kv.Replaced:
+			replaced++
+		case kv.Capacity:
+			evicted++
+		}
+	})
+
+	cache.Set(0, 0)
+	cache.SetMany(map[int]int{0: 1, 1: 1})
+	if replaced != 1 {
+		t.Errorf("Result was incorrect, got: %d replaced, want: %d.", replaced, 1)
+	}
+
+	items := make(map[int]int)
+	for i := 0; i < 100; i++ {
+		items[i] = i
+	}
+	cache.SetMany(items)
+
+	//0 and 1 are replaced again unless they were evicted first, depending on the map's order.
+	if n := cache.Count(); n != 64 || n + evicted + replaced != 103 {
+		t.Errorf("Result was incorrect, got: %d evicted %d replaced with %d left, want: %d left.", evicted, replaced, n, 64)
+	}
+}
+
+func TestForEachMode(t *testing.T) {
+	cache := New[int, int](2048, 32)
+	for i := 0; i < 100; i++ {
+		cache.Set(i, i)
+	}
+
+	//the callback writing to the cache would deadlock if the shard was still locked.
+	cache.ForEachMode(kv.IterCopy, func(k int, v int) bool {
+		cache.Set(k, v + 1)
+		return true
+	})
+	for i := 0; i < 100; i++ {
+		if res := cache.Get(i); res != i + 1 {
+			t.Errorf("Result was incorrect, got: %d, want: %d.", res, i + 1)
+		}
+	}
+
+	for _, mode := range []kv.IterMode{kv.IterLocked, kv.IterCopy} {
+		var n int
+		cache.ForEachMode(mode, func(k int, v int) bool {
+			n++
+			return n < 10
+		})
+		if n != 10 {
+			t.Errorf("ForEachMode didn't stop, got: %d calls, want: %d.", n, 10)
+		}
+	}
+
+	var sum int
+	cache.ForEach(func(k int, v int) {
+		sum += v
+	})
+	if sum != 5050 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", sum, 5050)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	cache := New[int, int](8192, 32)
+	for i := 0; i < 100; i++ {
+		cache.Set(i, i)
+	}
+
+	snap := cache.Snapshot()
+	cache.Set(0, 100)
+	cache.Delete(1)
+	cache.Set(200, 200)
+
+	if res := snap.Get(0); res != 0 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", res, 0)
+	}
+	if !snap.Has(1) || snap.Has(200) {
+		t.Errorf("Snapshot saw writes made after it was taken")
+	}
+	if res := cache.Get(0); res != 100 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", res, 100)
+	}
+
+	cache.Clear()
+	if n := snap.Count(); n != 100 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", n, 100)
+	}
+
+	var sum int
+	for _, v := range snap.All() {
+		sum += v
+	}
+	if sum != 4950 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", sum, 4950)
+	}
+}
+
+func TestSnapshotConsistent(t *testing.T) {
+	cache := New[int, int](8192, 32)
+
+	//keys are set in order, so every snapshot must hold a prefix of them.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			cache.Set(i, i)
+		}
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+
+		snap := cache.Snapshot()
+		n := snap.Count()
+		for i := 0; i < n; i++ {
+			if !snap.Has(i) {
+				t.Fatalf("Snapshot of %d items is missing %d.", n, i)
+			}
+		}
+	}
+}
+
+func TestMaxCost(t *testing.T) {
+	evicted := make(map[int]int)
+	cache, err := NewFromConfig(Config[int, string]{
+		Capacity: 1024,
+		Shards: 1,
+		MaxCost: 100,
+		Weigher: func(k int, v string) int64 {
+			return int64(len(v))
+		},
+		OnEvictedReason: func(k int, v string, r kv.Reason) {
+			if r == kv.Capacity {
+				evicted[k]++
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	val := string(make([]byte, 30))
+	for i := 0; i < 4; i++ {
+		cache.Set(i, val)
+	}
+	if cache.Cost() != 90 || cache.Count() != 3 || len(evicted) != 1 {
+		t.Errorf("Result was incorrect, got: %d cost %d items %d evicted, want: %d cost %d items %d evicted.", cache.Cost(), cache.Count(), len(evicted), 90, 3, 1)
+	}
+
+	cache.Set(10, string(make([]byte, 150)))
+	if cache.Has(10) || evicted[10] != 1 {
+		t.Errorf("Item bigger than the max cost was kept")
+	}
+
+	var sum int64
+	cache.Range(func(k int, v string) bool {
+		sum += int64(len(v))
+		return true
+	})
+	if sum != cache.Cost() {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", cache.Cost(), sum)
+	}
+
+	cache.Clear()
+	if cache.Cost() != 0 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", cache.Cost(), 0)
+	}
+}
+
+func TestMaxCostShards(t *testing.T) {
+	var evicted atomic.Int64
+	cache, err := NewFromConfig(Config[int, int]{
+		Capacity: 8192,
+		Shards: 16,
+		MaxCost: 16 * 100,
+		Weigher: func(k int, v int) int64 {
+			return int64(v)
+		},
+		OnEvictedReason: func(k int, v int, r kv.Reason) {
+			if r == kv.Capacity {
+				evicted.Add(1)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		cache.Set(i, i % 10 + 1)
+	}
+
+	if cost := cache.Cost(); cost > 16 * 100 {
+		t.Errorf("Result was incorrect, got: %d, want at most: %d.", cost, 16 * 100)
+	}
+	if n := int64(cache.Count()) + evicted.Load(); n != 1000 {
+		t.Errorf("Items went missing without being evicted, got: %d, want: %d.", n, 1000)
+	}
+
+	if _, err := NewFromConfig(Config[int, int]{Capacity: 8192, Shards: 16, MaxCost: -1}); !errors.Is(err, kv.ErrInvalidConfig) {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", err, kv.ErrInvalidConfig)
+	}
+}
+
+// Checks the budget is shared by the shards, so an item can cost more than MaxCost/Shards.
+func TestMaxCostGlobal(t *testing.T) {
+	var evicted atomic.Int64
+	cache, err := NewFromConfig(Config[int, int]{
+		Capacity: 1024,
+		Shards: 32,
+		MaxCost: 64,
+		Weigher: func(k int, v int) int64 {
+			return int64(v)
+		},
+		OnEvictedReason: func(k int, v int, r kv.Reason) {
+			if r == kv.Capacity {
+				evicted.Add(1)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set(0, 5)
+	if !cache.Has(0) || cache.Cost() != 5 {
+		t.Errorf("Result was incorrect, got: %v with cost %d, want: %v with cost %d.", cache.Has(0), cache.Cost(), true, 5)
+	}
+
+	//filling the cache with cheap items, then adding big ones evicts from every shard.
+	for i := 1; i <= 200; i++ {
+		cache.Set(i, 1)
+	}
+	for i := 1000; i < 1010; i++ {
+		cache.Set(i, 20)
+		if !cache.Has(i) {
+			t.Errorf("Item %d fitting in MaxCost wasn't kept", i)
+		}
+		if cost := cache.Cost(); cost > 64 {
+			t.Errorf("Result was incorrect, got: %d, want at most: %d.", cost, 64)
+		}
+	}
+	if n := int64(cache.Count()) + evicted.Load(); n != 211 {
+		t.Errorf("Items went missing without being evicted, got: %d, want: %d.", n, 211)
+	}
+}
+
+// Checks growing an item over the budget reports if it was kept, whichever item the policy evicts.
+func TestMaxCostReplace(t *testing.T) {
+	ops := map[string]func(c *Cache[int, int]) (int, bool){
+		"Compute": func(c *Cache[int, int]) (int, bool) {
+			return c.Compute(1, func(old int, exists bool) (int, kv.ComputeOp) {
+				return 9, kv.StoreOp
+			})
+		},
+		"Update": func(c *Cache[int, int]) (int, bool) {
+			return 9, c.Update(1, 9) == nil
+		},
+	}
+
+	for name, newPolicy := range map[string]func(int) Policy[int]{
+		"LRU": NewLRU[int],
+		"FIFO": NewFIFO[int],
+		"SIEVE": NewSIEVE[int],
+		"S3FIFO": NewS3FIFO[int],
+		"ARC": NewARC[int],
+		"TinyLFU": NewTinyLFU[int],
+	} {
+		for opName, op := range ops {
+			cache, err := NewFromConfig(Config[int, int]{
+				Capacity: 64,
+				Shards: 1,
+				MaxCost: 10,
+				Policy: newPolicy,
+				Weigher: func(k int, v int) int64 {
+					return int64(v)
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 1; i <= 3; i++ {
+				cache.Set(i, 1)
+			}
+			val, ok := op(cache)
+
+			if ok != cache.Has(1) || (ok && val != 9) {
+				t.Errorf("%s %s: Result was incorrect, got: %d %v with key kept %v, want: the key's real value.", name, opName, val, ok, cache.Has(1))
+			}
+			//FIFO evicts the oldest item whatever was done with it.
+			if name == "FIFO" && ok {
+				t.Errorf("%s %s: Result was incorrect, got: %v, want: %v.", name, opName, ok, false)
+			}
+			if cost := cache.Cost(); cost > 10 {
+				t.Errorf("%s %s: Result was incorrect, got: %d, want at most: %d.", name, opName, cost, 10)
+			}
+
+			var sum int64
+			cache.Range(func(k int, v int) bool {
+				sum += int64(v)
+				return true
+			})
+			if sum != cache.Cost() {
+				t.Errorf("%s %s: Result was incorrect, got: %d, want: %d.", name, opName, cache.Cost(), sum)
+			}
+		}
+	}
+}
+
+// Checks the budget is enforced through every shard's policy instead of emptying the shard of
+// the item being added.
+func TestMaxCostAcrossShards(t *testing.T) {
+	var evicted []int
+	cache, err := NewFromConfig(Config[int, int]{
+		Capacity: 64,
+		Shards: 4,
+		MaxCost: 11,
+		Hasher: func(k int) uint64 {
+			return uint64(k)
+		},
+		Weigher: func(k int, v int) int64 {
+			return int64(v)
+		},
+		OnEvictedReason: func(k int, v int, r kv.Reason) {
+			evicted = append(evicted, k)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//shard 0 holds 4 and 0, 4 being read after, the other shards three items each.
+	cache.Set(4, 1)
+	cache.Set(0, 1)
+	for _, k := range []int{1, 2, 3, 5, 6, 7, 9, 10, 11} {
+		cache.Set(k, 1)
+	}
+	cache.GetRenew(4)
+
+	cache.Set(8, 4)
+
+	want := []int{1, 2, 3, 0}
+	if fmt.Sprint(evicted) != fmt.Sprint(want) {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", evicted, want)
+	}
+	if !cache.Has(4) || !cache.Has(8) || cache.Cost() != 11 {
+		t.Errorf("Result was incorrect, got: %v %v with cost %d, want: %v %v with cost %d.", cache.Has(4), cache.Has(8), cache.Cost(), true, true, 11)
+	}
+}
+
+// Checks Add errors instead of reporting an item that costs more than MaxCost as added.
+func TestMaxCostAdd(t *testing.T) {
+	cache, err := NewFromConfig(Config[int, int]{
+		Capacity: 64,
+		Shards: 2,
+		MaxCost: 10,
+		Weigher: func(k int, v int) int64 {
+			return int64(v)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cache.Add(1, 11); err == nil || cache.Has(1) {
+		t.Errorf("Result was incorrect, got: %v, want: an error.", err)
+	}
+	if err := cache.Add(1, 10); err != nil || !cache.Has(1) {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", err, nil)
+	}
+}
+
+// Checks negative costs count as 0 instead of lowering the cost of the cache.
+func TestNegativeCost(t *testing.T) {
+	cache, err := NewFromConfig(Config[int, int]{
+		Capacity: 64,
+		Shards: 1,
+		MaxCost: 10,
+		Weigher: func(k int, v int) int64 {
+			return int64(v)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		cache.Set(i, -1)
+	}
+	if cost := cache.Cost(); cost != 0 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", cost, 0)
+	}
+
+	cache.Set(100, 10)
+	cache.Set(101, 10)
+	if cost := cache.Cost(); cost != 10 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", cost, 10)
+	}
+}
+
+func TestLRUOrder(t *testing.T) {
+	cache := New[int, int](4, 1)
+
+	var evicted []int
+	cache.SetOnEvictedReason(func(k int, v int, r kv.Reason) {
+		if r == kv.Capacity {
+			evicted = append(evicted, k)
+		}
+	})
+
+	for i := 1; i <= 4; i++ {
+		cache.Set(i, i)
+	}
+	cache.GetRenew(1)
+	cache.Set(5, 5)
+	cache.Set(6, 6)
+	cache.Set(4, 40)
+	cache.Set(7, 7)
+	cache.Has(5)
+	cache.Set(8, 8)
+
+	want := []int{2, 3, 1, 5}
+	if fmt.Sprint(evicted) != fmt.Sprint(want) {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", evicted, want)
+	}
+
+	for _, k := range []int{6, 4, 7, 8} {
+		if !cache.Has(k) {
+			t.Errorf("Most recently used key %d was evicted", k)
+		}
+	}
+}
+
+// Checks only the Renew reads change the order of eviction.
+func TestGetDoesntRenew(t *testing.T) {
+	cache := New[int, int](2, 1)
+
+	var evicted []int
+	cache.SetOnEvictedReason(func(k int, v int, r kv.Reason) {
+		evicted = append(evicted, k)
+	})
+
+	cache.Set(1, 1)
+	cache.Set(2, 2)
+	cache.Get(1)
+	cache.GetHas(1)
+	cache.GetMany([]int{1})
+	cache.Set(3, 3)
+	cache.GetHasRenew(2)
+	cache.Set(4, 4)
+
+	want := []int{1, 3}
+	if fmt.Sprint(evicted) != fmt.Sprint(want) {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", evicted, want)
+	}
+}
+
+func TestLRUExactSize(t *testing.T) {
+	cache := New[int, int](256, 4)
+
+	var evicted int
+	cache.SetOnEvicted(func(k int, v int) {
+		evicted++
+	})
+
+	for i := 0; i < 10000; i++ {
+		cache.Set(i, i)
+		cache.Delete(i - 50)
+	}
+
+	for _, shard := range cache.shards {
+		if n := shard.Map.Count(); n > 64 {
+			t.Errorf("Result was incorrect, got: %d items, want at most: %d.", n, 64)
+		}
+	}
+	if n := cache.Count(); n + evicted != 50 {
+		t.Errorf("Result was incorrect, got: %d left %d evicted, want: %d.", n, evicted, 50)
+	}
+}
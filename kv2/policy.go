@@ -0,0 +1,77 @@
+package kv2
+
+import (
+	"github.com/saintwish/kv/internal/list"
+)
+
+// Decides which items a shard evicts when it's full. Every shard has its own policy, made by
+// the factory in Config.Policy with the shard's capacity, and only calls it while holding its
+// write lock, so implementations don't need to be safe for concurrent use.
+type Policy[K comparable] interface {
+	// Called when key is added, returns a handle the shard keeps with the item and passes back.
+	OnInsert(key K) int32
+	// Called when the item of handle h is read with GetRenew or overwritten.
+	OnAccess(h int32)
+	// Called when the item of handle h is deleted or evicted, h isn't used again after.
+	OnDelete(h int32)
+	// Gets the key of the item to evict next, only called when the policy holds an item.
+	Victim() K
+	// Forgets every item.
+	Clear()
+}
+
+// A Policy that only marks items on reads, so the shard can call OnRead under its read lock and
+// reads of a shard can run in parallel. OnAccess is still used for overwrites.
+type ReadPolicy[K comparable] interface {
+	Policy[K]
+	// Called when the item of handle h is read with GetRenew, must be safe to call concurrently with itself.
+	OnRead(h int32)
+}
+
+// Evicts the least recently used item.
+type LRU[K comparable] struct {
+	nodes *list.Arena[K]
+	order list.List //from least to most recently used
+}
+
+func NewLRU[K comparable](capacity int) Policy[K] {
+	return &LRU[K]{
+		nodes: list.NewArena[K](capacity),
+	}
+}
+
+func (p *LRU[K]) OnInsert(key K) int32 {
+	return p.nodes.PushBack(&p.order, key)
+}
+
+func (p *LRU[K]) OnAccess(h int32) {
+	p.nodes.MoveToBack(&p.order, h)
+}
+
+func (p *LRU[K]) OnDelete(h int32) {
+	p.nodes.Remove(&p.order, h)
+}
+
+func (p *LRU[K]) Victim() K {
+	return *p.nodes.Value(p.nodes.Front(&p.order))
+}
+
+func (p *LRU[K]) Clear() {
+	p.nodes.Clear()
+	p.order = list.List{}
+}
+
+// Evicts the oldest item, reads and overwrites don't change the order.
+type FIFO[K comparable] struct {
+	LRU[K]
+}
+
+func NewFIFO[K comparable](capacity int) Policy[K] {
+	return &FIFO[K]{
+		LRU: LRU[K]{
+			nodes: list.NewArena[K](capacity),
+		},
+	}
+}
+
+func (p *FIFO[K]) OnAccess(h int32) {}
@@ -0,0 +1,425 @@
+package kv2
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/saintwish/kv"
+)
+
+var policies = map[string]func(int) Policy[int]{
+	"LRU": NewLRU[int],
+	"FIFO": NewFIFO[int],
+	"TinyLFU": NewTinyLFU[int],
+	"SIEVE": NewSIEVE[int],
+	"S3FIFO": NewS3FIFO[int],
+	"ARC": NewARC[int],
+}
+
+// Checks the contract every Policy must keep.
+func TestPolicyConformance(t *testing.T) {
+	for name, newPolicy := range policies {
+		t.Run(name, func(t *testing.T) {
+			testPolicy(t, newPolicy)
+		})
+	}
+}
+
+func testPolicy(t *testing.T, newPolicy func(int) Policy[int]) {
+	p := newPolicy(100)
+	handles := make(map[int]int32)
+	for i := 0; i < 100; i++ {
+		handles[i] = p.OnInsert(i)
+	}
+
+	//deleted items are never picked, reads don't lose any.
+	for i := 0; i < 100; i += 2 {
+		p.OnDelete(handles[i])
+		delete(handles, i)
+	}
+	for i := 1; i < 100; i += 4 {
+		p.OnAccess(handles[i])
+	}
+
+	seen := make(map[int]bool)
+	for len(handles) > 0 {
+		key := p.Victim()
+		h, ok := handles[key]
+		if !ok || seen[key] {
+			t.Fatalf("Victim returned %d, which isn't held", key)
+		}
+
+		seen[key] = true
+		p.OnDelete(h)
+		delete(handles, key)
+	}
+	if len(seen) != 50 {
+		t.Errorf("Result was incorrect, got: %d victims, want: %d.", len(seen), 50)
+	}
+
+	p.OnInsert(1000)
+	p.Clear()
+	h := p.OnInsert(2000)
+	if key := p.Victim(); key != 2000 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", key, 2000)
+	}
+	p.OnDelete(h)
+
+	//every item that leaves a cache using the policy is accounted for.
+	var evicted, deleted int
+	inserted := make(map[int]bool)
+	cache, err := NewFromConfig(Config[int, int]{
+		Capacity: 64,
+		Shards: 1,
+		Policy: newPolicy,
+		OnEvictedReason: func(k int, v int, r kv.Reason) {
+			switch r {
+			case kv.Capacity:
+				evicted++
+			case kv.Deleted:
+				deleted++
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	added := 0
+	for i := 0; i < 20000; i++ {
+		key := rng.Intn(200)
+		switch rng.Intn(3) {
+		case 0:
+			if cache.Add(key, key) == nil {
+				added++
+				inserted[key] = true
+			}
+		case 1:
+			cache.GetRenew(key)
+		case 2:
+			cache.DeleteCallback(key)
+		}
+
+		if n := cache.Count(); n > 64 {
+			t.Fatalf("Result was incorrect, got: %d items, want at most: %d.", n, 64)
+		}
+	}
+
+	if n := cache.Count(); n + evicted + deleted != added {
+		t.Errorf("Items went missing, got: %d left %d evicted %d deleted, want: %d.", n, evicted, deleted, added)
+	}
+}
+
+func drain(p Policy[int], handles map[int]int32) (order []int) {
+	for len(handles) > 0 {
+		key := p.Victim()
+		p.OnDelete(handles[key])
+		delete(handles, key)
+		order = append(order, key)
+	}
+
+	return
+}
+
+func TestPolicyOrder(t *testing.T) {
+	tests := []struct{
+		name string
+		newPolicy func(int) Policy[int]
+		want []int
+	}{
+		{"LRU", NewLRU[int], []int{1, 3, 0, 2}},
+		{"FIFO", NewFIFO[int], []int{0, 1, 2, 3}},
+		{"SIEVE", NewSIEVE[int], []int{1, 3, 0, 2}},
+		{"ARC", NewARC[int], []int{1, 3, 0, 2}},
+	}
+
+	for _, tt := range tests {
+		p := tt.newPolicy(4)
+		handles := make(map[int]int32)
+		for i := 0; i < 4; i++ {
+			handles[i] = p.OnInsert(i)
+		}
+		p.OnAccess(handles[0])
+		p.OnAccess(handles[2])
+
+		if got := drain(p, handles); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: Result was incorrect, got: %v, want: %v.", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Runs a skewed workload over a cache using newPolicy, setting every missed key.
+func hitRatio(t *testing.T, newPolicy func(int) Policy[int]) float64 {
+	stats := &kv.Stats{}
+	cache, err := NewFromConfig(Config[int, int]{
+		Capacity: 1000,
+		Shards: 1,
+		Policy: newPolicy,
+		Metrics: stats,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, 100000)
+	for i := 0; i < 200000; i++ {
+		key := int(zipf.Uint64())
+		if _, ok := cache.GetHasRenew(key); !ok {
+			cache.Set(key, key)
+		}
+	}
+
+	return stats.HitRatio()
+}
+
+func TestHitRatio(t *testing.T) {
+	lru := hitRatio(t, NewLRU[int])
+	for name, newPolicy := range map[string]func(int) Policy[int]{
+		"TinyLFU": NewTinyLFU[int],
+		"SIEVE": NewSIEVE[int],
+		"S3FIFO": NewS3FIFO[int],
+		"ARC": NewARC[int],
+	} {
+		if got := hitRatio(t, newPolicy); got <= lru {
+			t.Errorf("Result was incorrect, got: %s %.3f, want: more than LRU %.3f.", name, got, lru)
+		}
+	}
+}
+
+// Checks an admitted candidate isn't pitted against the victims again, so evictions go on in the
+// order of probation.
+func TestTinyLFUAdmission(t *testing.T) {
+	p := NewTinyLFU[int](100).(*TinyLFU[int])
+	handles := make(map[int]int32)
+	for i := 0; i < 100; i++ {
+		handles[i] = p.OnInsert(i)
+	}
+
+	//1000 has been seen more than the items on probation when it leaves the window.
+	for i := 0; i < 5; i++ {
+		p.OnDelete(p.OnInsert(1000))
+	}
+	handles[1000] = p.OnInsert(1000)
+	handles[1001] = p.OnInsert(1001)
+	if p.candidate != handles[1000] {
+		t.Fatalf("Result was incorrect, got: %d, want: %d.", p.candidate, handles[1000])
+	}
+
+	//asking again without evicting gives the same victim.
+	first := p.Victim()
+	for i := 0; i < 3; i++ {
+		if key := p.Victim(); key != first {
+			t.Errorf("Result was incorrect, got: %d, want: %d.", key, first)
+		}
+	}
+	if first == 1000 || p.candidate != 0 {
+		t.Fatalf("Result was incorrect, got: %d with candidate %d, want: 1000 admitted.", first, p.candidate)
+	}
+
+	//even hotter items on probation are evicted before 1000 now, in their order.
+	for i := 0; i < 3; i++ {
+		key := p.Victim()
+		if key == 1000 {
+			t.Fatalf("Admitted candidate was evicted")
+		}
+		for j := 0; j < 10; j++ {
+			p.sketch.increment(p.hasher.Hash(key))
+		}
+		if again := p.Victim(); again != key {
+			t.Errorf("Result was incorrect, got: %d, want: %d.", again, key)
+		}
+
+		p.OnDelete(handles[key])
+		delete(handles, key)
+	}
+}
+
+// Checks a burst of keys read once doesn't flush the popular ones out of a TinyLFU cache.
+func TestTinyLFUOneHitWonders(t *testing.T) {
+	cache, err := NewFromConfig(Config[int, int]{
+		Capacity: 100,
+		Shards: 1,
+		Policy: NewTinyLFU[int],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for r := 0; r < 5; r++ {
+		for i := 0; i < 50; i++ {
+			if _, ok := cache.GetHasRenew(i); !ok {
+				cache.Set(i, i)
+			}
+		}
+	}
+	for i := 1000; i < 2000; i++ {
+		cache.Set(i, i)
+	}
+
+	kept := 0
+	for i := 0; i < 50; i++ {
+		if cache.Has(i) {
+			kept++
+		}
+	}
+	if kept < 45 {
+		t.Errorf("Result was incorrect, got: %d popular items kept, want at least: %d.", kept, 45)
+	}
+}
+
+// Checks reads of a ReadPolicy cache only take the read lock, racing with writes under -race.
+func TestReadPolicyConcurrent(t *testing.T) {
+	for _, newPolicy := range []func(int) Policy[int]{NewSIEVE[int], NewS3FIFO[int]} {
+		cache, err := NewFromConfig(Config[int, int]{
+			Capacity: 256,
+			Shards: 4,
+			Policy: newPolicy,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cache.shards[0].reader == nil {
+			t.Fatalf("Result was incorrect, got: %T, want: a ReadPolicy.", cache.shards[0].policy)
+		}
+
+		var wg sync.WaitGroup
+		for w := 0; w < 8; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < 5000; i++ {
+					key := (i * (w+1)) % 512
+					if w%2 == 0 {
+						cache.Set(key, key)
+					} else if v, ok := cache.GetHasRenew(key); ok && v != key {
+						t.Errorf("Result was incorrect, got: %d, want: %d.", v, key)
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+
+		if n := cache.Count(); n > 256 {
+			t.Errorf("Result was incorrect, got: %d items, want at most: %d.", n, 256)
+		}
+	}
+}
+
+// Renewing reads of hot keys from every core, showing how much the write lock of LRU costs.
+func BenchmarkParallelGet(b *testing.B) {
+	for _, bb := range []struct{
+		name string
+		newPolicy func(int) Policy[int]
+	}{
+		{"LRU", NewLRU[int]},
+		{"TinyLFU", NewTinyLFU[int]},
+		{"SIEVE", NewSIEVE[int]},
+		{"S3FIFO", NewS3FIFO[int]},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			cache, err := NewFromConfig(Config[int, int]{
+				Capacity: 4096,
+				Shards: 16,
+				Policy: bb.newPolicy,
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			for i := 0; i < 1024; i++ {
+				cache.Set(i, i)
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					cache.GetRenew(i & 1023)
+					i++
+				}
+			})
+		})
+	}
+}
+
+// Reads a hot set that fits in the cache twice between scans of keys never seen again, returning
+// the hit ratio of the hot set's reads.
+func scanHitRatio(t *testing.T, newPolicy func(int) Policy[int]) float64 {
+	cache, err := NewFromConfig(Config[int, int]{
+		Capacity: 100,
+		Shards: 1,
+		Policy: newPolicy,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hits, reads int
+	scan := 1000
+	for r := 0; r < 50; r++ {
+		for i := 0; i < 100; i++ {
+			if _, ok := cache.GetHasRenew(i%50); ok {
+				hits++
+			} else {
+				cache.Set(i%50, i%50)
+			}
+			reads++
+		}
+		for i := 0; i < 200; i++ {
+			if _, ok := cache.GetHasRenew(scan); !ok {
+				cache.Set(scan, scan)
+			}
+			scan++
+		}
+	}
+
+	return float64(hits) / float64(reads)
+}
+
+func TestScanResistance(t *testing.T) {
+	lru := scanHitRatio(t, NewLRU[int])
+	arc := scanHitRatio(t, NewARC[int])
+	if arc < 0.9 || arc <= lru {
+		t.Errorf("Result was incorrect, got: ARC %.3f, want: at least 0.9 and more than LRU %.3f.", arc, lru)
+	}
+}
+
+// Checks ARC keeps keys read twice through a scan and grows t1 when its ghosts come back.
+func TestARCAdapts(t *testing.T) {
+	p := NewARC[int](4).(*ARC[int])
+	handles := make(map[int]int32)
+	insert := func(key int) {
+		for len(handles) >= 4 {
+			evictOne(p, handles)
+		}
+		handles[key] = p.OnInsert(key)
+	}
+
+	for _, key := range []int{0, 1} {
+		insert(key)
+		p.OnAccess(handles[key])
+	}
+	for key := 10; key < 20; key++ {
+		insert(key)
+	}
+	if _, ok := handles[0]; !ok || handles[1] == 0 || p.target != 0 {
+		t.Errorf("Result was incorrect, got: %v target %d, want: 0 and 1 kept, target %d.", handles, p.target, 0)
+	}
+
+	//16 was evicted from t1, so t1 should have been bigger.
+	if g, ok := p.ghostKeys[16]; !ok || g.frequent {
+		t.Fatalf("Result was incorrect, got: %v, want: 16 in b1.", g)
+	}
+	insert(16)
+	if _, ok := p.ghostKeys[16]; ok || !p.nodes.Value(handles[16]).frequent || p.target != 1 {
+		t.Errorf("Result was incorrect, got: target %d, want: 16 in t2 and target %d.", p.target, 1)
+	}
+}
+
+func evictOne(p Policy[int], handles map[int]int32) {
+	key := p.Victim()
+	p.OnDelete(handles[key])
+	delete(handles, key)
+}
@@ -0,0 +1,123 @@
+package kv2
+
+import (
+	"github.com/saintwish/kv/internal/list"
+)
+
+const s3MaxFreq = 3
+
+type s3Entry[K comparable] struct {
+	key K
+	main bool
+}
+
+// S3-FIFO, new items go in a small FIFO queue and only move to the main one if they're read
+// before reaching its end, otherwise they're evicted and remembered in a ghost queue so they go
+// straight to main if they come back. Items read while in main get another lap instead of being
+// evicted. Reads only bump a counter, so they don't need the write lock.
+type S3FIFO[K comparable] struct {
+	nodes *list.Arena[s3Entry[K]]
+	small, main list.List //each from oldest to newest
+	smallSize int
+	freq visits
+
+	ghosts *list.Arena[K]
+	ghost list.List //keys recently evicted from small, from oldest to newest
+	ghostKeys map[K]int32
+	ghostSize int
+}
+
+func NewS3FIFO[K comparable](capacity int) Policy[K] {
+	small := max(capacity/10, 1)
+	return &S3FIFO[K]{
+		nodes: list.NewArena[s3Entry[K]](capacity),
+		smallSize: small,
+		freq: make(visits, 0, capacity+1),
+		ghosts: list.NewArena[K](capacity),
+		ghostKeys: make(map[K]int32, capacity),
+		ghostSize: max(capacity-small, 1),
+	}
+}
+
+func (p *S3FIFO[K]) OnInsert(key K) (h int32) {
+	if g, ok := p.ghostKeys[key]; ok {
+		p.ghosts.Remove(&p.ghost, g)
+		delete(p.ghostKeys, key)
+		h = p.nodes.PushBack(&p.main, s3Entry[K]{key: key, main: true})
+	} else {
+		h = p.nodes.PushBack(&p.small, s3Entry[K]{key: key})
+	}
+
+	p.freq.reset(h)
+	return
+}
+
+func (p *S3FIFO[K]) OnRead(h int32) {
+	p.freq.add(h, s3MaxFreq)
+}
+
+func (p *S3FIFO[K]) OnAccess(h int32) {
+	p.OnRead(h)
+}
+
+func (p *S3FIFO[K]) OnDelete(h int32) {
+	p.nodes.Remove(p.queue(h), h)
+}
+
+func (p *S3FIFO[K]) queue(h int32) *list.List {
+	if p.nodes.Value(h).main {
+		return &p.main
+	}
+
+	return &p.small
+}
+
+// Gets the oldest item of small if it's full, or of main otherwise, moving read items out of
+// the way first. An item leaving small is remembered as a ghost.
+func (p *S3FIFO[K]) Victim() K {
+	for {
+		if p.small.Len() > 0 && (p.small.Len() >= p.smallSize || p.main.Len() == 0) {
+			h := p.nodes.Front(&p.small)
+			e := p.nodes.Value(h)
+			if p.freq.load(h) == 0 {
+				p.remember(e.key)
+				return e.key
+			}
+
+			p.nodes.Unlink(&p.small, h)
+			e.main = true
+			p.nodes.LinkBack(&p.main, h)
+			p.freq.store(h, 0)
+			continue
+		}
+
+		h := p.nodes.Front(&p.main)
+		n := p.freq.load(h)
+		if n == 0 {
+			return p.nodes.Value(h).key
+		}
+
+		p.nodes.MoveToBack(&p.main, h)
+		p.freq.store(h, n-1)
+	}
+}
+
+// Adds key to the ghost queue, forgetting the oldest ghost if it's full.
+func (p *S3FIFO[K]) remember(key K) {
+	if p.ghost.Len() >= p.ghostSize {
+		g := p.ghosts.Front(&p.ghost)
+		delete(p.ghostKeys, p.ghosts.Remove(&p.ghost, g))
+	}
+
+	p.ghostKeys[key] = p.ghosts.PushBack(&p.ghost, key)
+}
+
+func (p *S3FIFO[K]) Clear() {
+	p.nodes.Clear()
+	p.small, p.main = list.List{}, list.List{}
+	p.freq = p.freq[:0]
+
+	p.ghosts.Clear()
+	p.ghost = list.List{}
+	clear(p.ghostKeys)
+}
@@ -2,30 +2,58 @@ package kv2
 
 import (
 	"sync"
+	"sync/atomic"
 
+	"github.com/saintwish/kv"
 	"github.com/saintwish/kv/swiss"
-	"github.com/saintwish/kv/stack"
 )
 
 type item[V any] struct {
 	Object V
-	Index int
+	handle int32 //given by the shard's policy when the item was added
+	cost int64 //what the weigher said the item costs
 }
 
 //used internally
 type shardCapacity[K comparable, V any] struct {
 	Map *swiss.Map[K, item[V]]
-	Stack *stack.Stack[K]
+	policy Policy[K] //picks the items to evict
+	reader ReadPolicy[K] //policy if it can be told about reads under the read lock, nil otherwise
+	size int //max number of items
+	weigher func(K, V) int64 //nil if every item costs 1
+	maxCost int64 //the cache's MaxCost, 0 if unbounded
+	cost int64 //total cost of the shard's items
+	total *atomic.Int64 //total cost of the cache's items
+	shared bool //Map is held by a snapshot, so it's copied before being changed
 	sync.RWMutex //mutex
 }
 
-func newShardCapacity[K comparable, V any](size uint64, count uint64) *shardCapacity[K, V] {
-	return &shardCapacity[K, V] {
+func newShardCapacity[K comparable, V any](size uint64, count uint64, policy func(int) Policy[K], total *atomic.Int64) *shardCapacity[K, V] {
+	m := &shardCapacity[K, V] {
 		Map: swiss.NewMap[K, item[V]]( uint32(size/count) ),
-		Stack: stack.New[K](int(size/count)),
+		policy: policy(int(size/count)),
+		size: int(size/count),
+		total: total,
+	}
+	m.reader, _ = m.policy.(ReadPolicy[K])
+
+	return m
+}
+
+//Copies Map if a snapshot holds it, called with the write lock before anything can change Map.
+func (m *shardCapacity[K, V]) own() {
+	if m.shared {
+		m.Map = m.Map.Clone()
+		m.shared = false
 	}
 }
 
+//Marks Map as held by a snapshot and returns it, must hold the write lock.
+func (m *shardCapacity[K, V]) share() *swiss.Map[K, item[V]] {
+	m.shared = true
+	return m.Map
+}
+
 func (m *shardCapacity[K, V]) has(key K) bool {
 	m.RLock()
 
@@ -36,131 +64,349 @@ func (m *shardCapacity[K, V]) has(key K) bool {
 	return ok
 }
 
+//Gets the value of key without telling the policy, so it only needs the read lock.
+func (m *shardCapacity[K, V]) getHas(key K) (V, bool) {
+	m.RLock()
+
+	ok,val := m.Map.GetHas(key)
+
+	m.RUnlock()
+
+	return val.Object, ok
+}
+
+//Gets the value of key and tells the policy it was used, which needs the write lock unless
+//the policy is a ReadPolicy.
 func (m *shardCapacity[K, V]) getHasRenew(key K) (V, bool) {
+	if m.reader != nil {
+		m.RLock()
+
+		ok,val := m.Map.GetHas(key)
+		if ok {
+			m.reader.OnRead(val.handle)
+		}
+
+		m.RUnlock()
+
+		return val.Object, ok
+	}
+
 	m.Lock()
 
 	ok,val := m.Map.GetHas(key)
-	val.Index = m.Stack.MoveToBack(val.Index)
-	m.Map.Set(key, val)
+	if ok {
+		m.policy.OnAccess(val.handle)
+	}
 
 	m.Unlock()
 
 	return val.Object, ok
 }
 
-func (m *shardCapacity[K, V]) getHas(key K) (V, bool) {
+//Adds the values of keys that exist to vals.
+func (m *shardCapacity[K, V]) getMany(keys []K, vals map[K]V) {
 	m.RLock()
 
-	ok,val := m.Map.GetHas(key)
+	for _, key := range keys {
+		if ok, v := m.Map.GetHas(key); ok {
+			vals[key] = v.Object
+		}
+	}
 
 	m.RUnlock()
+}
 
-	return val.Object, ok
+/*--------
+	Other functions
+----------*/
+func (m *shardCapacity[K, V]) set(key K, val V, callback func(K, V, kv.Reason)) {
+	m.Lock()
+	m.own()
+
+	if ok, old := m.Map.GetHas(key); ok {
+		m.replace(key, val, old, callback)
+	} else {
+		m.insert(key, val, callback)
+	}
+
+	m.Unlock()
 }
 
-func (m *shardCapacity[K, V]) getRenew(key K) V {
+//Sets key only if it's missing, returns if it existed and if it was set, which it isn't if it
+//costs more than the cache can hold.
+func (m *shardCapacity[K, V]) add(key K, val V, callback func(K, V, kv.Reason)) (exists bool, ok bool) {
 	m.Lock()
+	m.own()
 
-	val := m.Map.Get(key)
-	val.Index = m.Stack.MoveToBack(val.Index)
-	m.Map.Set(key, val)
+	if exists = m.Map.Has(key); !exists {
+		ok = m.insert(key, val, callback)
+	}
 
 	m.Unlock()
 
-	return val.Object
+	return
 }
 
-func (m *shardCapacity[K, V]) get(key K) V {
-	m.RLock()
+//Sets key only if it exists, returns if it existed and if it was set, which it isn't if it costs
+//more than the cache can hold.
+func (m *shardCapacity[K, V]) update(key K, val V, callback func(K, V, kv.Reason)) (exists bool, ok bool) {
+	m.Lock()
+	m.own()
 
-	val := m.Map.Get(key)
+	var v item[V]
+	if exists,v = m.Map.GetHas(key); exists {
+		ok = m.replace(key, val, v, callback) && m.Map.Has(key)
+	}
 
-	m.RUnlock()
+	m.Unlock()
 
-	return val.Object
+	return
 }
 
-/*--------
-	Other functions
-----------*/
-func (m *shardCapacity[K, V]) set(key K, val V, callback func(K, V)) {
-	itm := item[V]{
+//Adds the missing key, evicting the policy's victims until the shard has room for it. The cache
+//evicts items for its MaxCost afterwards, see Cache.fit. Returns false if val costs more than the
+//whole cache can hold, in which case it's evicted right away. Must hold the write lock.
+func (m *shardCapacity[K, V]) insert(key K, val V, callback func(K, V, kv.Reason)) bool {
+	cost := m.weigh(key, val)
+	if m.maxCost > 0 && cost > m.maxCost {
+		callback(key, val, kv.Capacity)
+		return false
+	}
+
+	for m.Map.Count() >= m.size {
+		m.evict(callback)
+	}
+
+	m.Map.Set(key, item[V]{
 		Object: val,
+		handle: m.policy.OnInsert(key),
+		cost: cost,
+	})
+	m.addCost(cost)
+
+	return true
+}
+
+//Overwrites the existing item old of key, which counts as using it. Returns false like insert.
+//Must hold the write lock.
+func (m *shardCapacity[K, V]) replace(key K, val V, old item[V], callback func(K, V, kv.Reason)) bool {
+	cost := m.weigh(key, val)
+	if m.maxCost > 0 && cost > m.maxCost {
+		m.unlink(key, old)
+		callback(key, old.Object, kv.Replaced)
+		callback(key, val, kv.Capacity)
+		return false
+	}
+
+	m.Map.Set(key, item[V]{
+		Object: val,
+		handle: old.handle,
+		cost: cost,
+	})
+	m.policy.OnAccess(old.handle)
+	m.addCost(cost - old.cost)
+	callback(key, old.Object, kv.Replaced)
+
+	return true
+}
+
+//Gets the cost of an item, negative costs count as 0.
+func (m *shardCapacity[K, V]) weigh(key K, val V) int64 {
+	if m.weigher == nil {
+		return 1
 	}
 
+	return max(m.weigher(key, val), 0)
+}
+
+//Checks if adding cost would put the cache over its budget.
+func (m *shardCapacity[K, V]) overBudget(cost int64) bool {
+	return m.maxCost > 0 && m.total.Load() + cost > m.maxCost
+}
+
+//Evicts the policy's victim if the cache is over its budget and the shard has more than keep
+//items, returns its key and false if nothing was evicted.
+func (m *shardCapacity[K, V]) shed(keep int, callback func(K, V, kv.Reason)) (key K, ok bool) {
 	m.Lock()
+	m.own()
+
+	if ok = m.Map.Count() > keep && m.overBudget(0); ok {
+		key = m.evict(callback)
+	}
+
+	m.Unlock()
+
+	return
+}
+
+//Evicts the policy's victim and returns its key, must hold the write lock.
+func (m *shardCapacity[K, V]) evict(callback func(K, V, kv.Reason)) K {
+	key := m.policy.Victim()
+	_, v := m.Map.GetHas(key)
+	m.unlink(key, v)
+
+	callback(key, v.Object, kv.Capacity)
+
+	return key
+}
 
-	
-	if m.Map.Capacity() > 0 {
-		itm.Index = m.Stack.Push(key)
-		m.Map.Set(key, itm)
+//Removes the item itm of key from the map and policy, must hold the write lock.
+func (m *shardCapacity[K, V]) unlink(key K, itm item[V]) {
+	m.Map.Delete(key)
+	m.policy.OnDelete(itm.handle)
+	m.addCost(-itm.cost)
+}
+
+func (m *shardCapacity[K, V]) addCost(cost int64) {
+	m.cost += cost
+	m.total.Add(cost)
+}
+
+//Calls f with the value of key and applies its result.
+func (m *shardCapacity[K, V]) compute(key K, f func(V, bool) (V, kv.ComputeOp), callback func(K, V, kv.Reason)) (V, bool) {
+	m.Lock()
+	m.own()
+	defer m.Unlock()
+
+	exists, old := m.Map.GetHas(key)
+	val, op := f(old.Object, exists)
+	switch op {
+	case kv.StoreOp:
+		var ok bool
+		if exists {
+			ok = m.replace(key, val, old, callback)
+		} else {
+			ok = m.insert(key, val, callback)
+		}
+		if ok {
+			return val, true
+		}
+	case kv.DeleteOp:
+		if exists {
+			m.unlink(key, old)
+		}
+	default:
+		return old.Object, exists
 	}
 
-	if m.Map.Capacity() == 0 {
-		_, oldKey := m.Stack.Pop()
-		_,v := m.Map.Delete(oldKey)
-		callback(oldKey, v.Object)
+	var zero V
+	return zero, false
+}
+
+func (m *shardCapacity[K, V]) delete(key K) bool {
+	m.Lock()
+	m.own()
 
-		itm.Index = m.Stack.Push(key)
-		m.Map.Set(key, itm)
+	ok, val := m.Map.GetHas(key)
+	if ok {
+		m.unlink(key, val)
 	}
 
 	m.Unlock()
+
+	return ok
 }
 
-func (m *shardCapacity[K, V]) update(key K, val V) {
+//Sets every key in keys to its value in items, evicting items as needed like set.
+func (m *shardCapacity[K, V]) setMany(keys []K, items map[K]V, callback func(K, V, kv.Reason)) {
 	m.Lock()
+	m.own()
 
-	if ok,v := m.Map.GetHas(key); ok {
-		v.Object = val
-		v.Index = m.Stack.MoveToBack(v.Index)
-		m.Map.Set(key, v)
+	for _, key := range keys {
+		if ok, old := m.Map.GetHas(key); ok {
+			m.replace(key, items[key], old, callback)
+		} else {
+			m.insert(key, items[key], callback)
+		}
 	}
 
 	m.Unlock()
 }
 
-func (m *shardCapacity[K, V]) delete(key K) bool {
+func (m *shardCapacity[K, V]) deleteMany(keys []K) (count int) {
 	m.Lock()
+	m.own()
 
-	ok, val := m.Map.Delete(key)
-	m.Stack.Remove(val.Index)
+	for _, key := range keys {
+		if ok, val := m.Map.GetHas(key); ok {
+			m.unlink(key, val)
+			count++
+		}
+	}
 
 	m.Unlock()
 
-	return ok
+	return
 }
 
-func (m *shardCapacity[K, V]) deleteCallback(key K, callback func(K, V)) bool {
+func (m *shardCapacity[K, V]) deleteCallback(key K, callback func(K, V, kv.Reason)) bool {
 	m.Lock()
+	m.own()
 
-	ok, val := m.Map.Delete(key)
-	m.Stack.Remove(val.Index)
-	callback(key, val.Object)
+	ok, val := m.Map.GetHas(key)
+	if ok {
+		m.unlink(key, val)
+		callback(key, val.Object, kv.Deleted)
+	}
 
 	m.Unlock()
 
 	return ok
 }
 
+//Calls f for every item under the read lock, returns false if f stopped.
+func (m *shardCapacity[K, V]) iterate(f func(K, V) bool) (cont bool) {
+	m.RLock()
+
+	cont = true
+	m.Map.Iter(func(key K, val item[V]) (stop bool) {
+		cont = f(key, val.Object)
+		return !cont
+	})
+
+	m.RUnlock()
+
+	return
+}
+
+//Appends every item to keys and vals under the read lock.
+func (m *shardCapacity[K, V]) collect(keys []K, vals []V) ([]K, []V) {
+	m.RLock()
+
+	m.Map.Iter(func(key K, val item[V]) (stop bool) {
+		keys = append(keys, key)
+		vals = append(vals, val.Object)
+		return
+	})
+
+	m.RUnlock()
+
+	return keys, vals
+}
+
 func (m *shardCapacity[K, V]) clear() {
+	m.Lock()
+	m.own()
+
 	m.Map.Clear()
+	m.policy.Clear()
+	m.addCost(-m.cost)
+
+	m.Unlock()
 }
 
-func (m *shardCapacity[K, V]) flush(callback func(K, V)) {
+func (m *shardCapacity[K, V]) flush(callback func(K, V, kv.Reason)) {
 	m.Lock()
+	m.own()
 
-	m.Stack.Clear()
+	m.policy.Clear()
+	m.addCost(-m.cost)
 	m.Map.Iter(func(key K, val item[V]) (stop bool) {
-		callback(key, val.Object)
+		callback(key, val.Object, kv.Flushed)
 		m.Map.Delete(key)
-		
-		if stop {
-			return
-		}
 
 		return
 	})
 
 	m.Unlock()
-}
\ No newline at end of file
+}
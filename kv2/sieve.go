@@ -0,0 +1,103 @@
+package kv2
+
+import (
+	"sync/atomic"
+
+	"github.com/saintwish/kv/internal/list"
+)
+
+// Counters indexed by handle that can be bumped under a shard's read lock. They're only
+// resized while holding the write lock, so readers never see the slice change.
+type visits []uint32
+
+// Zeroes the counter of h, growing the slice if needed. Must hold the write lock.
+func (v *visits) reset(h int32) {
+	for int(h) >= len(*v) {
+		*v = append(*v, 0)
+	}
+
+	(*v)[h] = 0
+}
+
+func (v visits) load(h int32) uint32 {
+	return atomic.LoadUint32(&v[h])
+}
+
+func (v visits) store(h int32, n uint32) {
+	atomic.StoreUint32(&v[h], n)
+}
+
+// Adds one to the counter of h unless it's already at limit, skipping the write if it is
+// so hot items don't keep bouncing their cache line between cores.
+func (v visits) add(h int32, limit uint32) {
+	if n := v.load(h); n < limit {
+		atomic.CompareAndSwapUint32(&v[h], n, n+1)
+	}
+}
+
+// SIEVE, items are kept in insertion order and a hand walks from the oldest to the newest,
+// evicting the first item that wasn't read since the hand last passed it. Reads only set a
+// visited bit, so they don't need the write lock.
+type SIEVE[K comparable] struct {
+	nodes *list.Arena[K]
+	order list.List //from oldest to newest
+	hand int32 //next item to look at, 0 to start from the oldest
+	visited visits
+}
+
+func NewSIEVE[K comparable](capacity int) Policy[K] {
+	return &SIEVE[K]{
+		nodes: list.NewArena[K](capacity),
+		visited: make(visits, 0, capacity+1),
+	}
+}
+
+func (p *SIEVE[K]) OnInsert(key K) int32 {
+	h := p.nodes.PushBack(&p.order, key)
+	p.visited.reset(h)
+
+	return h
+}
+
+func (p *SIEVE[K]) OnRead(h int32) {
+	if p.visited.load(h) == 0 {
+		p.visited.store(h, 1)
+	}
+}
+
+func (p *SIEVE[K]) OnAccess(h int32) {
+	p.OnRead(h)
+}
+
+func (p *SIEVE[K]) OnDelete(h int32) {
+	if h == p.hand {
+		p.hand = p.nodes.Next(h)
+	}
+
+	p.nodes.Remove(&p.order, h)
+}
+
+func (p *SIEVE[K]) Victim() K {
+	h := p.hand
+	for {
+		if h == 0 {
+			h = p.nodes.Front(&p.order)
+		}
+		if p.visited.load(h) == 0 {
+			break
+		}
+
+		p.visited.store(h, 0)
+		h = p.nodes.Next(h)
+	}
+
+	p.hand = h
+	return *p.nodes.Value(h)
+}
+
+func (p *SIEVE[K]) Clear() {
+	p.nodes.Clear()
+	p.order = list.List{}
+	p.hand = 0
+	p.visited = p.visited[:0]
+}
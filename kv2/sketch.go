@@ -0,0 +1,70 @@
+package kv2
+
+import (
+	"math/bits"
+)
+
+const sketchDepth = 4
+
+// A count-min sketch estimating how often keys were seen. Counters take a byte each but stop
+// at 15, which is enough to rank keys. Once reset increments have been counted every counter
+// is halved, so old popularity fades.
+type sketch struct {
+	counters []uint8 //sketchDepth rows of width counters
+	mask uint64 //width - 1, width being a power of two
+	added int //increments since the last halving, halved along with the counters
+	reset int
+}
+
+func newSketch(capacity int) *sketch {
+	width := 1 << bits.Len(uint(max(capacity, 16)-1))
+	return &sketch{
+		counters: make([]uint8, sketchDepth*width),
+		mask: uint64(width-1),
+		reset: 10 * max(capacity, 1),
+	}
+}
+
+var sketchSeeds = [sketchDepth]uint64{0xc3a5c85c97cb3127, 0xb492b66fbe98f273, 0x9ae16a3b2f90404f, 0xcbf29ce484222325}
+
+// Gets the index of the counter of hash in row i, every row mixing hash with its own seed.
+func (s *sketch) index(hash uint64, i int) uint64 {
+	h := (hash ^ sketchSeeds[i]) * 0x9e3779b97f4a7c15
+	h ^= h >> 32
+	return uint64(i)*(s.mask+1) + h&s.mask
+}
+
+func (s *sketch) increment(hash uint64) {
+	for i := 0; i < sketchDepth; i++ {
+		if c := &s.counters[s.index(hash, i)]; *c < 15 {
+			*c++
+		}
+	}
+
+	if s.added++; s.added >= s.reset {
+		s.age()
+	}
+}
+
+// Gets the estimated number of times hash was seen.
+func (s *sketch) estimate(hash uint64) (n uint8) {
+	n = 15
+	for i := 0; i < sketchDepth; i++ {
+		n = min(n, s.counters[s.index(hash, i)])
+	}
+
+	return
+}
+
+// Halves every counter, a counter at 15 dropping to 7.
+func (s *sketch) age() {
+	for i := range s.counters {
+		s.counters[i] >>= 1
+	}
+	s.added /= 2
+}
+
+func (s *sketch) clear() {
+	clear(s.counters)
+	s.added = 0
+}
@@ -0,0 +1,131 @@
+package kv2
+
+import (
+	"github.com/dolthub/maphash"
+	"github.com/saintwish/kv/internal/list"
+)
+
+const (
+	windowSegment uint8 = iota
+	probationSegment
+	protectedSegment
+)
+
+type lfuEntry[K comparable] struct {
+	key K
+	hash uint64
+	segment uint8
+}
+
+// W-TinyLFU, new items go through a small LRU window and then have to beat the main space's
+// victim on estimated frequency to be kept, so items seen once can't push out popular ones.
+// The main space is a segmented LRU, items read again while on probation are protected.
+type TinyLFU[K comparable] struct {
+	nodes *list.Arena[lfuEntry[K]]
+	window, probation, protected list.List //each from least to most recently used
+	windowSize, protectedSize int
+	candidate int32 //the last item moved out of the window, 0 if it's been settled
+	sketch *sketch
+	hasher maphash.Hasher[K]
+}
+
+func NewTinyLFU[K comparable](capacity int) Policy[K] {
+	window := max(capacity/100, 1)
+	return &TinyLFU[K]{
+		nodes: list.NewArena[lfuEntry[K]](capacity),
+		windowSize: window,
+		protectedSize: max(capacity-window, 0) * 8 / 10,
+		sketch: newSketch(capacity),
+		hasher: maphash.NewHasher[K](),
+	}
+}
+
+func (p *TinyLFU[K]) segment(s uint8) *list.List {
+	switch s {
+	case probationSegment:
+		return &p.probation
+	case protectedSegment:
+		return &p.protected
+	}
+
+	return &p.window
+}
+
+func (p *TinyLFU[K]) OnInsert(key K) int32 {
+	hash := p.hasher.Hash(key)
+	p.sketch.increment(hash)
+
+	h := p.nodes.PushBack(&p.window, lfuEntry[K]{key: key, hash: hash})
+	if p.window.Len() > p.windowSize {
+		p.candidate = p.nodes.Front(&p.window)
+		p.move(p.candidate, probationSegment)
+	}
+
+	return h
+}
+
+func (p *TinyLFU[K]) OnAccess(h int32) {
+	e := p.nodes.Value(h)
+	p.sketch.increment(e.hash)
+
+	switch e.segment {
+	case probationSegment:
+		if h == p.candidate {
+			p.candidate = 0
+		}
+		p.move(h, protectedSegment)
+
+		if p.protected.Len() > p.protectedSize {
+			p.move(p.nodes.Front(&p.protected), probationSegment)
+		}
+	default:
+		p.nodes.MoveToBack(p.segment(e.segment), h)
+	}
+}
+
+func (p *TinyLFU[K]) OnDelete(h int32) {
+	if h == p.candidate {
+		p.candidate = 0
+	}
+
+	p.nodes.Remove(p.segment(p.nodes.Value(h).segment), h)
+}
+
+// Gets the loser between the candidate, the last item to leave the window, and the least
+// recently used item on probation, the candidate only winning if it's been seen more often.
+func (p *TinyLFU[K]) Victim() K {
+	victim := p.nodes.Front(&p.probation)
+	if victim == 0 {
+		victim = p.nodes.Front(&p.protected)
+	}
+	if victim == 0 {
+		return p.nodes.Value(p.nodes.Front(&p.window)).key
+	}
+
+	if p.candidate != 0 && p.candidate != victim {
+		c, v := p.nodes.Value(p.candidate), p.nodes.Value(victim)
+		if p.sketch.estimate(c.hash) <= p.sketch.estimate(v.hash) {
+			victim = p.candidate
+		} else {
+			//admitted, it's now an ordinary item on probation.
+			p.candidate = 0
+		}
+	}
+
+	return p.nodes.Value(victim).key
+}
+
+func (p *TinyLFU[K]) Clear() {
+	p.nodes.Clear()
+	p.window, p.probation, p.protected = list.List{}, list.List{}, list.List{}
+	p.candidate = 0
+	p.sketch.clear()
+}
+
+// Moves node h to the back of segment s.
+func (p *TinyLFU[K]) move(h int32, s uint8) {
+	e := p.nodes.Value(h)
+	p.nodes.Unlink(p.segment(e.segment), h)
+	e.segment = s
+	p.nodes.LinkBack(p.segment(s), h)
+}
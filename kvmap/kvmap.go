@@ -2,8 +2,10 @@ package kvmap
 
 import (
 	"fmt"
+	"iter"
 	
 	"github.com/dolthub/maphash"
+	"github.com/saintwish/kv"
 )
 
 type Cache[K comparable, V any] struct {
@@ -12,8 +14,11 @@ type Cache[K comparable, V any] struct {
 	hash maphash.Hasher[K]
 
 	OnEvicted func(K, V) //function that's called when cached item is deleted by the system
+	OnEvictedReason func(K, V, kv.Reason) //same as OnEvicted but also told why, called for replaced items too
 }
 
+var _ kv.Cache[string, any] = (*Cache[string, any])(nil)
+
 func New[K comparable, V any](sc uint64) *Cache[K, V] {
 	cache := Cache[K, V] {}
 	cache.shards = make([]*shardMap[K, V], sc)
@@ -42,9 +47,24 @@ func (c *Cache[K, V]) SetOnEvicted(f func(K, V)) {
 	c.OnEvicted = f
 }
 
+func (c *Cache[K, V]) SetOnEvictedReason(f func(K, V, kv.Reason)) {
+	c.OnEvictedReason = f
+}
+
+// Calls the eviction callbacks, OnEvicted keeps its old behaviour of not being called for replaced items.
+func (c *Cache[K, V]) evicted(key K, val V, reason kv.Reason) {
+	if c.OnEvictedReason != nil {
+		c.OnEvictedReason(key, val, reason)
+	}
+
+	if c.OnEvicted != nil && reason != kv.Replaced {
+		c.OnEvicted(key, val)
+	}
+}
+
 func (c *Cache[K, V]) Set(key K, val V) {
 	shard := c.getShard(key)
-	shard.set(key, val)
+	shard.set(key, val, c.evicted)
 }
 
 func (c *Cache[K, V]) Get(key K) V {
@@ -52,6 +72,11 @@ func (c *Cache[K, V]) Get(key K) V {
 	return shard.get(key)
 }
 
+func (c *Cache[K, V]) GetHas(key K) (V, bool) {
+	shard := c.getShard(key)
+	return shard.getHas(key)
+}
+
 func (c *Cache[K, V]) Has(key K) bool {
 	shard := c.getShard(key)
 	shard.RLock()
@@ -67,49 +92,124 @@ func (c *Cache[K, V]) Has(key K) bool {
 func (c *Cache[K, V]) Add(key K, val V) (err error) {
 	shard := c.getShard(key)
 	shard.Lock()
+	defer shard.Unlock()
 
 	if _, ok := shard.Map[key]; ok {
-		err = fmt.Errorf("kvmap: Data already exists with given key %T", key)
-		shard.Unlock()
-		return
+		return fmt.Errorf("kvmap: Data already exists with given key %T", key)
 	}
-	
-	c.Set(key, val)
 
-	shard.Unlock()
+	shard.Map[key] = val
 	return nil
 }
 
 func (c *Cache[K, V]) Update(key K, val V) (err error) {
 	shard := c.getShard(key)
 	shard.Lock()
+	defer shard.Unlock()
 
-	if _, ok := shard.Map[key]; !ok {
+	old, ok := shard.Map[key]
+	if !ok {
 		return fmt.Errorf("kvmap: Data doesn't exists with given key %T", key)
-		shard.Unlock()
-		return
 	}
 
-	c.Set(key, val)
-
-	shard.Unlock()
+	shard.Map[key] = val
+	c.evicted(key, old, kv.Replaced)
 	return nil
 }
 
-func (c *Cache[K, V]) Delete(key K) {
-	if c.Has(key) {
-		shard := c.getShard(key)
+// Deletes key and returns boolean if sucessful.
+func (c *Cache[K, V]) Delete(key K) bool {
+	shard := c.getShard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	_, ok := shard.Map[key]
+	delete(shard.Map, key)
+
+	return ok
+}
+
+// Deletes key and returns boolean if sucessful, calling the eviction callbacks.
+func (c *Cache[K, V]) DeleteCallback(key K) bool {
+	shard := c.getShard(key)
+	shard.Lock()
+	defer shard.Unlock()
+
+	val, ok := shard.Map[key]
+	if ok {
 		delete(shard.Map, key)
+		c.evicted(key, val, kv.Deleted)
+	}
+
+	return ok
+}
+
+// Gets the current amount of elements in the cache.
+func (c *Cache[K, V]) Count() (count int) {
+	for i := 0; i < len(c.shards); i++ {
+		shard := c.shards[i]
+		shard.RLock()
+		count = count + len(shard.Map)
+		shard.RUnlock()
+	}
+	return
+}
+
+// Clears the cache without calling OnEviction callback
+func (c *Cache[K, V]) Clear() {
+	for i := 0; i < len(c.shards); i++ {
+		shard := c.shards[i]
+		shard.Lock()
+		clear(shard.Map)
+		shard.Unlock()
+	}
+}
+
+// Calls f for every item until it returns false. Shards are read locked one at a time
+// while f is called for their items, so f must not write to the cache.
+func (c *Cache[K, V]) Range(f func(key K, val V) bool) {
+	for i := 0; i < len(c.shards); i++ {
+		if !c.shards[i].iterate(f) {
+			return
+		}
+	}
+}
+
+// Returns an iterator over the items for use with range, it works like Range so breaking
+// out of the loop stops it and the loop body must not write to the cache.
+func (c *Cache[K, V]) All() iter.Seq2[K, V] {
+	return c.Range
+}
+
+// Returns an iterator over the keys, see All.
+func (c *Cache[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		c.Range(func(key K, _ V) bool {
+			return yield(key)
+		})
 	}
 }
 
+// Returns an iterator over the values, see All.
+func (c *Cache[K, V]) Values() iter.Seq[V] {
+	return func(yield func(V) bool) {
+		c.Range(func(_ K, val V) bool {
+			return yield(val)
+		})
+	}
+}
+
+// Clears the cache with calling OnEviction callback
 func (c *Cache[K, V]) Flush() {
 	for i := 0; i < len(c.shards); i++ {
 		shard := c.shards[i]
+		shard.Lock()
 
 		for k,v := range shard.Map {
-			c.OnEvicted(k, v)
+			c.evicted(k, v, kv.Flushed)
 			delete(shard.Map, k)
 		}
+
+		shard.Unlock()
 	}
-}
\ No newline at end of file
+}
@@ -3,6 +3,10 @@ package kvmap
 import (
 	"fmt"
 	"testing"
+	"time"
+
+	"github.com/saintwish/kv"
+	"github.com/saintwish/kv/kvtest"
 )
 
 func TestSetGet_KeyString(t *testing.T) {
@@ -23,6 +27,50 @@ func TestSetGet_KeyInt(t *testing.T) {
 	}
 }
 
+// Checks Add and Update release the shard lock whatever they return.
+func TestAddUpdate(t *testing.T) {
+	cache := New[int, string](32)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		if err := cache.Add(1, "a"); err != nil {
+			t.Errorf("Result was incorrect, got: %v, want: %v.", err, nil)
+		}
+		if err := cache.Add(1, "b"); err == nil {
+			t.Errorf("Add of an existing key didn't error")
+		}
+		if err := cache.Update(1, "c"); err != nil {
+			t.Errorf("Result was incorrect, got: %v, want: %v.", err, nil)
+		}
+		if err := cache.Update(2, "d"); err == nil {
+			t.Errorf("Update of a missing key didn't error")
+		}
+		cache.Set(2, "e")
+		cache.Delete(2)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5*time.Second):
+		t.Fatal("Add or Update deadlocked")
+	}
+
+	if res := cache.Get(1); res != "c" {
+		t.Errorf("Result was incorrect, got: %s, want: %s.", res, "c")
+	}
+	if cache.Has(2) {
+		t.Errorf("Deleted key still exists")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	kvtest.Run(t, func() kv.Cache[string, int] {
+		return New[string, int](32)
+	})
+}
+
 func TestFlush(t *testing.T) {
 	cache := New[int, string](32)
 	cache.SetOnEvicted(func(k int, v string){
@@ -35,4 +83,29 @@ func TestFlush(t *testing.T) {
 	cache.Set(5465, "leet haxiors3")
 
 	cache.Flush()
-}
\ No newline at end of file
+}
+
+func TestEvictedReason(t *testing.T) {
+	cache := New[int, string](32)
+	reasons := map[int][]kv.Reason{}
+	cache.SetOnEvictedReason(func(k int, v string, r kv.Reason) {
+		reasons[k] = append(reasons[k], r)
+	})
+
+	cache.Set(1, "one")
+	cache.Set(1, "uno")
+	cache.Set(2, "two")
+	cache.DeleteCallback(2)
+	cache.DeleteCallback(3)
+	cache.Flush()
+
+	if res := reasons[1]; len(res) != 2 || res[0] != kv.Replaced || res[1] != kv.Flushed {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", res, []kv.Reason{kv.Replaced, kv.Flushed})
+	}
+	if res := reasons[2]; len(res) != 1 || res[0] != kv.Deleted {
+		t.Errorf("Result was incorrect, got: %v, want: %v.", res, []kv.Reason{kv.Deleted})
+	}
+	if res := reasons[3]; len(res) != 0 {
+		t.Errorf("Callback called for missing key, got: %v.", res)
+	}
+}
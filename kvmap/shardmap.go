@@ -2,6 +2,8 @@ package kvmap
 
 import (
 	"sync"
+
+	"github.com/saintwish/kv"
 )
 
 //used internally
@@ -16,14 +18,42 @@ func newShardMap[K comparable, V any]() *shardMap[K, V] {
 	}
 }
 
-func (m *shardMap[K, V]) set(key K, val V) {
+func (m *shardMap[K, V]) set(key K, val V, callback func(K, V, kv.Reason)) {
 	m.Lock()
 
+	old, ok := m.Map[key]
 	m.Map[key] = val
+	if ok {
+		callback(key, old, kv.Replaced)
+	}
 
 	m.Unlock()
 }
 
+func (m *shardMap[K, V]) getHas(key K) (val V, ok bool) {
+	m.RLock()
+
+	val, ok = m.Map[key]
+
+	m.RUnlock()
+
+	return
+}
+
+//Calls f for every item under the read lock, returns false if f stopped.
+func (m *shardMap[K, V]) iterate(f func(K, V) bool) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	for k, v := range m.Map {
+		if !f(k, v) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (m *shardMap[K, V]) get(key K) (val V) {
 	m.RLock()
 
@@ -0,0 +1,252 @@
+// Package kvtest is a conformance suite for implementations of kv.Cache.
+package kvtest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/saintwish/kv"
+)
+
+// Runs the suite against caches made by newCache, which must return an empty cache
+// with room for at least a few hundred items on every call.
+func Run(t *testing.T, newCache func() kv.Cache[string, int]) {
+	t.Run("SetGet", func(t *testing.T) {
+		c := newCache()
+		c.Set("unicorns", 1)
+
+		if res := c.Get("unicorns"); res != 1 {
+			t.Errorf("Result was incorrect, got: %d, want: %d.", res, 1)
+		}
+		if res := c.Get("missing"); res != 0 {
+			t.Errorf("Result was incorrect, got: %d, want: %d.", res, 0)
+		}
+
+		c.Set("unicorns", 2)
+		if res, ok := c.GetHas("unicorns"); !ok || res != 2 {
+			t.Errorf("Result was incorrect, got: %d %t, want: %d %t.", res, ok, 2, true)
+		}
+		if _, ok := c.GetHas("missing"); ok {
+			t.Errorf("GetHas found a missing key")
+		}
+	})
+
+	t.Run("AddUpdate", func(t *testing.T) {
+		c := newCache()
+
+		if err := c.Update("key", 1); err == nil {
+			t.Errorf("Update of a missing key should error")
+		}
+		if err := c.Add("key", 1); err != nil {
+			t.Errorf("Add errored: %v", err)
+		}
+		if err := c.Add("key", 2); err == nil {
+			t.Errorf("Add of an existing key should error")
+		}
+		if err := c.Update("key", 3); err != nil {
+			t.Errorf("Update errored: %v", err)
+		}
+		if res := c.Get("key"); res != 3 {
+			t.Errorf("Result was incorrect, got: %d, want: %d.", res, 3)
+		}
+	})
+
+	t.Run("DeleteCountClear", func(t *testing.T) {
+		c := newCache()
+		for i := 0; i < 100; i++ {
+			c.Set(fmt.Sprint(i), i)
+		}
+
+		if n := c.Count(); n != 100 {
+			t.Errorf("Result was incorrect, got: %d, want: %d.", n, 100)
+		}
+		if !c.Delete("5") {
+			t.Errorf("Delete of an existing key returned false")
+		}
+		if c.Delete("5") {
+			t.Errorf("Delete of a missing key returned true")
+		}
+		if _, ok := c.GetHas("5"); ok {
+			t.Errorf("Deleted key still exists")
+		}
+		if n := c.Count(); n != 99 {
+			t.Errorf("Result was incorrect, got: %d, want: %d.", n, 99)
+		}
+
+		c.Clear()
+		if n := c.Count(); n != 0 {
+			t.Errorf("Result was incorrect, got: %d, want: %d.", n, 0)
+		}
+		if _, ok := c.GetHas("6"); ok {
+			t.Errorf("Cleared key still exists")
+		}
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		c := newCache()
+		want := make([]string, 0, 100)
+		for i := 0; i < 100; i++ {
+			c.Set(fmt.Sprint(i), i)
+			want = append(want, fmt.Sprint(i))
+		}
+
+		var got []string
+		c.Range(func(key string, val int) bool {
+			if key != fmt.Sprint(val) {
+				t.Errorf("Range gave %s the value %d", key, val)
+			}
+			got = append(got, key)
+			return true
+		})
+
+		sort.Strings(got)
+		sort.Strings(want)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Range visited %d keys, want: %d.", len(got), len(want))
+		}
+
+		var n int
+		c.Range(func(key string, val int) bool {
+			n++
+			return n < 10
+		})
+		if n != 10 {
+			t.Errorf("Range didn't stop, got: %d calls, want: %d.", n, 10)
+		}
+	})
+
+	t.Run("Iterators", func(t *testing.T) {
+		c := newCache()
+		sum := 0
+		for i := 0; i < 100; i++ {
+			c.Set(fmt.Sprint(i), i)
+			sum += i
+		}
+
+		var got []string
+		for key, val := range c.All() {
+			if key != fmt.Sprint(val) {
+				t.Errorf("All gave %s the value %d", key, val)
+			}
+			got = append(got, key)
+		}
+		if len(got) != 100 {
+			t.Errorf("All visited %d keys, want: %d.", len(got), 100)
+		}
+
+		var keys []string
+		for key := range c.Keys() {
+			keys = append(keys, key)
+		}
+		sort.Strings(got)
+		sort.Strings(keys)
+		if fmt.Sprint(got) != fmt.Sprint(keys) {
+			t.Errorf("Keys visited %d keys, want: %d.", len(keys), len(got))
+		}
+
+		var total int
+		for val := range c.Values() {
+			total += val
+		}
+		if total != sum {
+			t.Errorf("Result was incorrect, got: %d, want: %d.", total, sum)
+		}
+
+		var n int
+		for range c.All() {
+			n++
+			if n == 10 {
+				break
+			}
+		}
+		for range c.Keys() {
+			n++
+			break
+		}
+		for range c.Values() {
+			n++
+			break
+		}
+		if n != 12 {
+			t.Errorf("Iterators didn't stop, got: %d calls, want: %d.", n, 12)
+		}
+	})
+
+	t.Run("ConcurrentAdd", func(t *testing.T) {
+		c := newCache()
+		const keys, workers = 200, 16
+
+		var wins [keys]atomic.Int32
+		var winner [keys]atomic.Int64
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				<-start
+				for i := 0; i < keys; i++ {
+					if c.Add(fmt.Sprint(i), w) == nil {
+						wins[i].Add(1)
+						winner[i].Store(int64(w))
+					}
+				}
+			}(w)
+		}
+		close(start)
+		wg.Wait()
+
+		for i := 0; i < keys; i++ {
+			if n := wins[i].Load(); n != 1 {
+				t.Errorf("Add of %d succeeded %d times, want: %d.", i, n, 1)
+			}
+			if res := c.Get(fmt.Sprint(i)); int64(res) != winner[i].Load() {
+				t.Errorf("Result was incorrect, got: %d, want: %d.", res, winner[i].Load())
+			}
+		}
+	})
+
+	t.Run("ConcurrentAddDelete", func(t *testing.T) {
+		c := newCache()
+		const workers, rounds = 8, 20000
+
+		var adds, deletes atomic.Int64
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				<-start
+				for i := 0; i < rounds; i++ {
+					switch (w + i) % 3 {
+					case 0:
+						if c.Add("key", w) == nil {
+							adds.Add(1)
+						}
+					case 1:
+						c.Update("key", w)
+					case 2:
+						if c.Delete("key") {
+							deletes.Add(1)
+						}
+					}
+				}
+			}(w)
+		}
+		close(start)
+		wg.Wait()
+
+		//every successful delete removed exactly one successful add.
+		var present int64
+		if _, ok := c.GetHas("key"); ok {
+			present = 1
+		}
+		if adds.Load() - deletes.Load() != present {
+			t.Errorf("Adds and deletes don't add up, got: %d adds %d deletes, with %d items left.", adds.Load(), deletes.Load(), present)
+		}
+	})
+}
@@ -0,0 +1,12 @@
+package kv
+
+import (
+	"context"
+	"errors"
+)
+
+// Loads the value of a key missing from a cache, used by GetOrLoad.
+type Loader[K comparable, V any] func(ctx context.Context, key K) (V, error)
+
+// Returned by GetOrLoad when the cache has no Loader set.
+var ErrNoLoader = errors.New("kv: no loader set")
@@ -0,0 +1,63 @@
+package kv
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// Returned, wrapped, by the config based constructors when the config is invalid.
+var ErrInvalidConfig = errors.New("kv: invalid config")
+
+// Receives the events of a cache, must be safe for concurrent use.
+type Metrics interface {
+	Hit() //a read found the key
+	Miss() //a read didn't find the key
+	Evict(reason Reason) //an item left the cache
+}
+
+// A Metrics counting events with atomics.
+type Stats struct {
+	hits atomic.Uint64
+	misses atomic.Uint64
+	evictions [Flushed+1]atomic.Uint64 //indexed by Reason
+}
+
+func (s *Stats) Hit() {
+	s.hits.Add(1)
+}
+
+func (s *Stats) Miss() {
+	s.misses.Add(1)
+}
+
+func (s *Stats) Evict(reason Reason) {
+	if int(reason) < len(s.evictions) {
+		s.evictions[reason].Add(1)
+	}
+}
+
+func (s *Stats) Hits() uint64 {
+	return s.hits.Load()
+}
+
+func (s *Stats) Misses() uint64 {
+	return s.misses.Load()
+}
+
+// Gets the number of items that left the cache for reason.
+func (s *Stats) Evictions(reason Reason) uint64 {
+	if int(reason) < len(s.evictions) {
+		return s.evictions[reason].Load()
+	}
+	return 0
+}
+
+// Gets the share of reads that were hits, 0 if there were none.
+func (s *Stats) HitRatio() float64 {
+	hits, misses := s.Hits(), s.Misses()
+	if hits+misses == 0 {
+		return 0
+	}
+
+	return float64(hits) / float64(hits+misses)
+}
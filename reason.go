@@ -0,0 +1,30 @@
+// Package kv holds the types shared by the caches in its subpackages.
+package kv
+
+// Tells an eviction callback why an item left the cache.
+type Reason uint8
+
+const (
+	Expired Reason = iota + 1 //the item's expiration passed
+	Capacity //the item was evicted to make room in a bounded cache
+	Deleted //the item was explicitly deleted
+	Replaced //the item was overwritten by a new value for the same key
+	Flushed //the whole cache was flushed
+)
+
+func (r Reason) String() string {
+	switch r {
+	case Expired:
+		return "expired"
+	case Capacity:
+		return "capacity"
+	case Deleted:
+		return "deleted"
+	case Replaced:
+		return "replaced"
+	case Flushed:
+		return "flushed"
+	}
+
+	return "unknown"
+}
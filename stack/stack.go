@@ -79,6 +79,7 @@ func (s *Stack[V]) MoveToBack(index int) (int) {
 //Clears the entire stack but keep allocated memory
 func (s *Stack[V]) Clear() {
 	s.stack = s.stack[:0]
+	s.size = 0
 }
 
 func (s *Stack[V]) Stack() []V {
@@ -22,6 +22,22 @@ func TestMoveToBack_KeyString(t *testing.T) {
 	fmt.Println(m.Stack())
 }
 
+func TestClear(t *testing.T) {
+	m := New[int](2048)
+
+	m.Push(1337)
+	m.Push(1338)
+	m.Push(1339)
+	m.Clear()
+
+	if index := m.Push(1400); index != 0 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", index, 0)
+	}
+	if index, val := m.Back(); index != 0 || val != 1400 {
+		t.Errorf("Result was incorrect, got: %d %d, want: %d %d.", index, val, 0, 1400)
+	}
+}
+
 func Benchmark_KV1_SZ2048(b *testing.B) {
 	m := New[int](2048)
 
@@ -16,6 +16,9 @@
 package swiss
 
 import (
+	"iter"
+	"slices"
+
 	"github.com/dolthub/maphash"
 )
 
@@ -262,6 +265,34 @@ func (m *Map[K, V]) Iter(cb func(k K, v V) (stop bool)) {
 	}
 }
 
+// All returns an iterator over the key-value pairs of |m|,
+// visiting them the same way as Iter.
+func (m *Map[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		m.Iter(func(k K, v V) bool {
+			return !yield(k, v)
+		})
+	}
+}
+
+// Keys returns an iterator over the keys of |m|.
+func (m *Map[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		m.Iter(func(k K, _ V) bool {
+			return !yield(k)
+		})
+	}
+}
+
+// Values returns an iterator over the values of |m|.
+func (m *Map[K, V]) Values() iter.Seq[V] {
+	return func(yield func(V) bool) {
+		m.Iter(func(_ K, v V) bool {
+			return !yield(v)
+		})
+	}
+}
+
 // Clear removes all elements from the Map.
 func (m *Map[K, V]) Clear() {
 	for i, c := range m.ctrl {
@@ -281,6 +312,14 @@ func (m *Map[K, V]) Clear() {
 	m.resident, m.dead = 0, 0
 }
 
+// Clone returns a copy of |m| sharing no memory with it.
+func (m *Map[K, V]) Clone() *Map[K, V] {
+	c := *m
+	c.ctrl = slices.Clone(m.ctrl)
+	c.groups = slices.Clone(m.groups)
+	return &c
+}
+
 // Count returns the number of elements in the Map.
 func (m *Map[K, V]) Count() int {
 	return int(m.resident - m.dead)
@@ -0,0 +1,117 @@
+package swiss
+
+import (
+	"testing"
+)
+
+func TestAll(t *testing.T) {
+	m := NewMap[int, int](16)
+	for i := 0; i < 100; i++ {
+		m.Set(i, i*2)
+	}
+
+	seen := make(map[int]int)
+	for k, v := range m.All() {
+		seen[k] = v
+	}
+	if len(seen) != 100 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", len(seen), 100)
+	}
+	for k, v := range seen {
+		if v != k*2 {
+			t.Errorf("Result was incorrect, got: %d, want: %d.", v, k*2)
+		}
+	}
+
+	var keys, vals int
+	for k := range m.Keys() {
+		if _, ok := seen[k]; !ok {
+			t.Errorf("Key %d wasn't set", k)
+		}
+		keys++
+	}
+	for range m.Values() {
+		vals++
+	}
+	if keys != 100 || vals != 100 {
+		t.Errorf("Result was incorrect, got: %d keys %d values, want: %d.", keys, vals, 100)
+	}
+}
+
+func TestAllBreak(t *testing.T) {
+	m := NewMap[int, int](16)
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+
+	//yield isn't called again once it returned false, which would panic with range.
+	n := 0
+	for range m.All() {
+		if n++; n == 10 {
+			break
+		}
+	}
+	for range m.Keys() {
+		if n++; n == 20 {
+			break
+		}
+	}
+	for range m.Values() {
+		if n++; n == 30 {
+			break
+		}
+	}
+	if n != 30 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", n, 30)
+	}
+}
+
+func TestAllEmpty(t *testing.T) {
+	m := NewMap[int, int](16)
+	m.Set(1, 1)
+	m.Delete(1)
+
+	for k := range m.All() {
+		t.Errorf("Key %d found in empty map", k)
+	}
+	for k := range m.Keys() {
+		t.Errorf("Key %d found in empty map", k)
+	}
+	for v := range m.Values() {
+		t.Errorf("Value %d found in empty map", v)
+	}
+}
+
+func TestClone(t *testing.T) {
+	m := NewMap[int, int](16)
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+
+	c := m.Clone()
+	m.Set(0, 100)
+	m.Delete(1)
+	m.Set(1000, 1000)
+	c.Set(2, 200)
+
+	if v := c.Get(0); v != 0 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", v, 0)
+	}
+	if !c.Has(1) || c.Has(1000) || c.Count() != 100 {
+		t.Errorf("Clone changed with the original map")
+	}
+	if v := m.Get(2); v != 2 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", v, 2)
+	}
+	if m.Count() != 100 {
+		t.Errorf("Result was incorrect, got: %d, want: %d.", m.Count(), 100)
+	}
+
+	//growing the clone mustn't touch the original either.
+	for i := 100; i < 1000; i++ {
+		c.Set(i, i)
+	}
+	if m.Has(500) || m.Count() != 100 {
+		t.Errorf("Original map changed with the clone")
+	}
+}